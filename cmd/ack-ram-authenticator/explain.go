@@ -0,0 +1,242 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/server"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Explain how a running server maps an ARN or token",
+	Long: `Explain asks a running server how it maps an ARN or the identity of a token:
+the canonical ARN, the result of each backend in chain order, the wildcard
+mappings matching the ARN, the account mapping and the user returned to
+Kubernetes. The request is authenticated with a token generated for the
+cluster, whose identity must be mapped to one of --explain-allowed-groups.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterID := viper.GetString("clusterID")
+		request := server.ExplainRequest{
+			ARN:         viper.GetString("explain.arn"),
+			SessionName: viper.GetString("explain.sessionName"),
+			ClusterID:   clusterID,
+			Token:       viper.GetString("explain.token"),
+		}
+
+		if clusterID == "" {
+			fmt.Fprintf(os.Stderr, "error: cluster ID not specified\n")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if (request.ARN == "") == (request.Token == "") {
+			fmt.Fprintf(os.Stderr, "error: exactly one of --arn and --token must be specified\n")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		gen, err := token.NewGenerator()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not get token: %v\n", err)
+			os.Exit(1)
+		}
+		tok, err := gen.GetWithOptions(&token.GetTokenOptions{
+			ClusterID:     clusterID,
+			AssumeRoleARN: viper.GetString("explain.role"),
+			Region:        viper.GetString("explain.region"),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not get token: %v\n", err)
+			os.Exit(1)
+		}
+
+		client, err := explainClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not create client: %v\n", err)
+			os.Exit(1)
+		}
+		explanation, err := requestExplanation(client, viper.GetString("explain.server"), tok.Token, request)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not explain mapping: %v\n", err)
+			os.Exit(1)
+		}
+
+		if viper.GetString("explain.output") == "json" {
+			value, err := json.MarshalIndent(explanation, "", "    ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not marshal explanation: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s\n", value)
+		} else {
+			printExplanation(explanation)
+		}
+		if !explanation.Authenticated {
+			os.Exit(2)
+		}
+	},
+}
+
+func explainClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: viper.GetBool("explain.insecureSkipTLSVerify"),
+	}
+	if caFile := viper.GetString("explain.caFile"); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	certFile, keyFile := viper.GetString("explain.clientCertFile"), viper.GetString("explain.clientKeyFile")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+func requestExplanation(client *http.Client, serverURL, bearer string, request server.ExplainRequest) (*server.Explanation, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(serverURL, "/")+server.ExplainPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	explanation := &server.Explanation{}
+	if err := json.NewDecoder(resp.Body).Decode(explanation); err != nil {
+		return nil, err
+	}
+	return explanation, nil
+}
+
+func printExplanation(e *server.Explanation) {
+	fmt.Printf("ARN:           %s\n", e.ARN)
+	fmt.Printf("Canonical ARN: %s\n", e.CanonicalARN)
+	fmt.Printf("Account:       %s\n", e.AccountID)
+	if e.SessionName != "" {
+		fmt.Printf("Session name:  %s\n", e.SessionName)
+	}
+	fmt.Printf("Chain policy:  %s\n", e.ChainPolicy)
+
+	if len(e.Steps) > 0 {
+		fmt.Println("\nBackends:")
+		for _, step := range e.Steps {
+			line := fmt.Sprintf("  %-8s %-16s %-8s", step.Phase, step.Backend, step.Result)
+			if step.Result == server.StepHit || step.Username != "" {
+				line += fmt.Sprintf(" username %q groups %q", step.Username, step.Groups)
+			}
+			if step.Error != "" {
+				line += " " + step.Error
+			}
+			fmt.Println(strings.TrimRight(line, " "))
+		}
+	}
+	if len(e.WildcardMatches) > 0 {
+		fmt.Println("\nMatching wildcard mappings:")
+		for _, match := range e.WildcardMatches {
+			source := match.Backend
+			if match.Object != "" {
+				source += "/" + match.Object
+			}
+			fmt.Printf("  %s %s username %q groups %q\n", source, match.Pattern, match.Username, match.Groups)
+		}
+	}
+
+	fmt.Println()
+	if !e.Authenticated {
+		fmt.Printf("Denied: %s\n", e.Error)
+		return
+	}
+	fmt.Printf("Username: %s\n", e.User.Username)
+	fmt.Printf("UID:      %s\n", e.User.UID)
+	fmt.Printf("Groups:   %s\n", strings.Join(e.User.Groups, ", "))
+	var keys []string
+	for key := range e.User.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		fmt.Println("Extra:")
+	}
+	for _, key := range keys {
+		fmt.Printf("  %s: %s\n", key, strings.Join(e.User.Extra[key], ", "))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+	explainCmd.Flags().String("arn", "", "ARN to explain the mapping of")
+	explainCmd.Flags().String("session-name", "", "Session name to explain the mapping with. Defaults to the session name of an assumed role ARN.")
+	explainCmd.Flags().StringP("token", "t", "", "Token whose identity to explain the mapping of, instead of --arn")
+	explainCmd.Flags().String("server", fmt.Sprintf("https://127.0.0.1:%d", DefaultPort), "URL of the server")
+	explainCmd.Flags().String("ca-file", "", "CA bundle to verify the server certificate with")
+	explainCmd.Flags().Bool("insecure-skip-tls-verify", false, "Do not verify the server certificate")
+	explainCmd.Flags().String("client-cert-file", "", "Client certificate to present when the server requires one")
+	explainCmd.Flags().String("client-key-file", "", "Key of --client-cert-file")
+	explainCmd.Flags().String("region", "", "AlibabaCloud region to use for assume role calls")
+	explainCmd.Flags().StringP("role", "r", "", "Assume an RAM Role ARN before signing the token authenticating the request")
+	explainCmd.Flags().StringP("output", "o", "", "Output format. Only `json` is supported currently.")
+	viper.BindPFlag("explain.arn", explainCmd.Flags().Lookup("arn"))
+	viper.BindPFlag("explain.sessionName", explainCmd.Flags().Lookup("session-name"))
+	viper.BindPFlag("explain.token", explainCmd.Flags().Lookup("token"))
+	viper.BindPFlag("explain.server", explainCmd.Flags().Lookup("server"))
+	viper.BindPFlag("explain.caFile", explainCmd.Flags().Lookup("ca-file"))
+	viper.BindPFlag("explain.insecureSkipTLSVerify", explainCmd.Flags().Lookup("insecure-skip-tls-verify"))
+	viper.BindPFlag("explain.clientCertFile", explainCmd.Flags().Lookup("client-cert-file"))
+	viper.BindPFlag("explain.clientKeyFile", explainCmd.Flags().Lookup("client-key-file"))
+	viper.BindPFlag("explain.region", explainCmd.Flags().Lookup("region"))
+	viper.BindPFlag("explain.role", explainCmd.Flags().Lookup("role"))
+	viper.BindPFlag("explain.output", explainCmd.Flags().Lookup("output"))
+}
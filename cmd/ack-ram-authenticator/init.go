@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/kubeconfig"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -49,7 +50,7 @@ var initCmd = &cobra.Command{
 		logrus.Infof("copy %s to %s on kubernetes master node(s)", localCfg.CertPath(), cfg.CertPath())
 		logrus.Infof("copy %s to %s on kubernetes master node(s)", localCfg.KeyPath(), cfg.KeyPath())
 		logrus.Infof("copy %s to %s on kubernetes master node(s)", localCfg.GenerateKubeconfigPath, cfg.GenerateKubeconfigPath)
-		logrus.Infof("configure your apiserver with `--authentication-token-webhook-config-file=%s` to enable authentication with ack-ram-authenticator", cfg.GenerateKubeconfigPath)
+		logrus.Infof("configure your apiserver with `--authentication-token-webhook-config-file=%s` and `--authentication-token-webhook-version=%s` to enable authentication with ack-ram-authenticator", cfg.GenerateKubeconfigPath, kubeconfig.WebhookTokenReviewVersion)
 	},
 }
 
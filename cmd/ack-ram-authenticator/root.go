@@ -20,7 +20,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/certs"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/server"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -82,16 +84,67 @@ func initConfig() {
 
 func getConfig() (config.Config, error) {
 	cfg := config.Config{
-		ClusterID:              viper.GetString("clusterID"),
-		Region:                 viper.GetString("server.region"),
-		HostPort:               viper.GetInt("server.port"),
-		Hostname:               viper.GetString("server.hostname"),
-		GenerateKubeconfigPath: viper.GetString("server.generateKubeconfig"),
-		KubeconfigPregenerated: viper.GetBool("server.kubeconfigPregenerated"),
-		StateDir:               viper.GetString("server.stateDir"),
-		Address:                viper.GetString("server.address"),
-		Kubeconfig:             viper.GetString("server.kubeconfig"),
-		BackendMode:            viper.GetStringSlice("server.backendMode"),
+		ClusterID:                    viper.GetString("clusterID"),
+		Region:                       viper.GetString("server.region"),
+		HostPort:                     viper.GetInt("server.port"),
+		Hostname:                     viper.GetString("server.hostname"),
+		GenerateKubeconfigPath:       viper.GetString("server.generateKubeconfig"),
+		KubeconfigPregenerated:       viper.GetBool("server.kubeconfigPregenerated"),
+		StateDir:                     viper.GetString("server.stateDir"),
+		Address:                      viper.GetString("server.address"),
+		Kubeconfig:                   viper.GetString("server.kubeconfig"),
+		BackendMode:                  viper.GetStringSlice("server.backendMode"),
+		MapperChainPolicy:            viper.GetString("server.mapperChainPolicy"),
+		MappingConflictCheckInterval: viper.GetDuration("server.mappingConflictCheckInterval"),
+		ExplainAllowedGroups:         viper.GetStringSlice("server.explainAllowedGroups"),
+		UIDStrategy:                  viper.GetString("server.uidStrategy"),
+		UIDTemplate:                  viper.GetString("server.uidTemplate"),
+		ExtraKeys:                    viper.GetStringSlice("server.extraKeys"),
+		ScrubbedAliyunAccounts:       viper.GetStringSlice("server.scrubbedAccounts"),
+		DenyListFile:                 viper.GetString("server.denyListFile"),
+		DenyListConfigMap:            viper.GetString("server.denyListConfigMap"),
+		DenyListConfigMapNamespace:   viper.GetString("server.denyListConfigMapNamespace"),
+		DenyListCRD:                  viper.GetBool("server.denyListCRD"),
+		AuditLogPath:                 viper.GetString("server.auditLogPath"),
+		AuditLogMaxSize:              viper.GetInt("server.auditLogMaxSize"),
+		AuditLogMaxBackups:           viper.GetInt("server.auditLogMaxBackups"),
+		AuditWebhookURL:              viper.GetString("server.auditWebhookURL"),
+		AuditWebhookBatchSize:        viper.GetInt("server.auditWebhookBatchSize"),
+		AuditWebhookBatchInterval:    viper.GetDuration("server.auditWebhookBatchInterval"),
+		Audiences:                    viper.GetStringSlice("server.audiences"),
+		TokenCacheSize:               viper.GetInt("server.tokenCacheSize"),
+		TokenCacheNegativeTTL:        viper.GetDuration("server.tokenCacheNegativeTTL"),
+		TokenClockSkew:               viper.GetDuration("server.tokenClockSkew"),
+		NonceStoreSize:               viper.GetInt("server.nonceStoreSize"),
+		NonceMaxReuses:               viper.GetInt("server.nonceMaxReuses"),
+		NonceReuseWindow:             viper.GetDuration("server.nonceReuseWindow"),
+		ClientAddressRateLimitQPS:    viper.GetFloat64("server.clientAddressRateLimitQPS"),
+		ClientAddressRateLimitBurst:  viper.GetInt("server.clientAddressRateLimitBurst"),
+		AccessKeyRateLimitQPS:        viper.GetFloat64("server.accessKeyRateLimitQPS"),
+		AccessKeyRateLimitBurst:      viper.GetInt("server.accessKeyRateLimitBurst"),
+		MaxConcurrentSTSCalls:        viper.GetInt("server.maxConcurrentSTSCalls"),
+		STSEndpoints:                 viper.GetStringSlice("server.stsEndpoints"),
+		STSMaxRetries:                viper.GetInt("server.stsMaxRetries"),
+		STSRetryBackoff:              viper.GetDuration("server.stsRetryBackoff"),
+		STSBreakerFailures:           viper.GetInt("server.stsBreakerFailures"),
+		STSBreakerCooldown:           viper.GetDuration("server.stsBreakerCooldown"),
+		ShutdownDelay:                viper.GetDuration("server.shutdownDelay"),
+		ShutdownGracePeriod:          viper.GetDuration("server.shutdownGracePeriod"),
+		TLSCertFile:                  viper.GetString("server.tlsCertFile"),
+		TLSKeyFile:                   viper.GetString("server.tlsKeyFile"),
+		ClientCAFile:                 viper.GetString("server.clientCAFile"),
+		AllowedClientNames:           viper.GetStringSlice("server.allowedClientNames"),
+		WebhookClientCertFile:        viper.GetString("server.webhookClientCertFile"),
+		WebhookClientKeyFile:         viper.GetString("server.webhookClientKeyFile"),
+		TLSMinVersion:                viper.GetString("server.tlsMinVersion"),
+		TLSMaxVersion:                viper.GetString("server.tlsMaxVersion"),
+		TLSCipherSuites:              viper.GetStringSlice("server.tlsCipherSuites"),
+		TLSCurvePreferences:          viper.GetStringSlice("server.tlsCurvePreferences"),
+		HealthAddress:                viper.GetString("server.healthAddress"),
+		HealthPort:                   viper.GetInt("server.healthPort"),
+		EnablePprof:                  viper.GetBool("server.enablePprof"),
+		ECSEndpoint:                  viper.GetString("server.ecsEndpoint"),
+		ECSInstanceCacheTTL:          viper.GetDuration("server.ecsInstanceCacheTTL"),
 	}
 	if err := viper.UnmarshalKey("server.mapRoles", &cfg.RoleMappings); err != nil {
 		return cfg, fmt.Errorf("invalid server role mappings: %v", err)
@@ -99,9 +152,12 @@ func getConfig() (config.Config, error) {
 	if err := viper.UnmarshalKey("server.mapUsers", &cfg.UserMappings); err != nil {
 		logrus.WithError(err).Fatal("invalid server user mappings")
 	}
-	if err := viper.UnmarshalKey("server.mapAccounts", &cfg.AutoMappedAlibabaCloudAccounts); err != nil {
+	if err := viper.UnmarshalKey("server.mapAccounts", &cfg.AutoMappedAlibabaCloudAccounts, viper.DecodeHook(config.AccountMappingDecodeHook)); err != nil {
 		logrus.WithError(err).Fatal("invalid server account mappings")
 	}
+	if err := viper.UnmarshalKey("server.clusters", &cfg.Clusters, viper.DecodeHook(config.AccountMappingDecodeHook)); err != nil {
+		return cfg, fmt.Errorf("invalid server clusters: %v", err)
+	}
 
 	if featureGates.Enabled(config.ConfiguredInitDirectories) {
 		logrus.Info("ConfiguredInitDirectories feature enabled")
@@ -110,6 +166,27 @@ func getConfig() (config.Config, error) {
 	if cfg.ClusterID == "" {
 		return cfg, errors.New("cluster ID cannot be empty")
 	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, errors.New("tls cert file and tls key file must be set together")
+	}
+	if len(cfg.AllowedClientNames) > 0 && cfg.ClientCAFile == "" {
+		return cfg, errors.New("allowed client names require a client CA file")
+	}
+	if err := certs.ValidateNamePatterns(cfg.AllowedClientNames); err != nil {
+		return cfg, err
+	}
+	if _, err := certs.ParseTLSPolicy(cfg.TLSMinVersion, cfg.TLSMaxVersion, cfg.TLSCipherSuites, cfg.TLSCurvePreferences); err != nil {
+		return cfg, err
+	}
+	if (cfg.WebhookClientCertFile == "") != (cfg.WebhookClientKeyFile == "") {
+		return cfg, errors.New("webhook client cert file and webhook client key file must be set together")
+	}
+	if cfg.ClientAddressRateLimitQPS > 0 && cfg.ClientAddressRateLimitBurst < 1 {
+		return cfg, errors.New("client address rate limit burst must be at least 1")
+	}
+	if cfg.AccessKeyRateLimitQPS > 0 && cfg.AccessKeyRateLimitBurst < 1 {
+		return cfg, errors.New("access key rate limit burst must be at least 1")
+	}
 
 	// DynamicFile BackendMode and DynamicFilePath are mutually inclusive.
 	var dynamicFileModeSet bool
@@ -128,6 +205,49 @@ func getConfig() (config.Config, error) {
 	if errs := mapper.ValidateBackendMode(cfg.BackendMode); len(errs) > 0 {
 		return cfg, utilerrors.NewAggregate(errs)
 	}
+	if err := mapper.ValidateChainPolicy(cfg.MapperChainPolicy); err != nil {
+		return cfg, err
+	}
+	if err := server.ValidateUIDStrategy(cfg.UIDStrategy, cfg.UIDTemplate); err != nil {
+		return cfg, err
+	}
+	if err := server.ValidateExtraKeys(cfg.ExtraKeys); err != nil {
+		return cfg, err
+	}
+
+	clusterIDs := map[string]bool{cfg.ClusterID: true}
+	for i := range cfg.Clusters {
+		cluster := &cfg.Clusters[i]
+		if cluster.ClusterID == "" {
+			return cfg, errors.New("cluster ID of server clusters cannot be empty")
+		}
+		if clusterIDs[cluster.ClusterID] {
+			return cfg, fmt.Errorf("cluster %s is configured more than once", cluster.ClusterID)
+		}
+		clusterIDs[cluster.ClusterID] = true
+		if len(cluster.BackendMode) == 0 {
+			cluster.BackendMode = []string{mapper.ModeMountedFile}
+		}
+		if errs := mapper.ValidateBackendMode(cluster.BackendMode); len(errs) > 0 {
+			return cfg, fmt.Errorf("cluster %s: %v", cluster.ClusterID, utilerrors.NewAggregate(errs))
+		}
+		dynamicFileModeSet := false
+		for _, mode := range cluster.BackendMode {
+			if mode == mapper.ModeDynamicFile {
+				dynamicFileModeSet = true
+			}
+		}
+		if dynamicFileModeSet != (cluster.DynamicFilePath != "") {
+			return cfg, fmt.Errorf("cluster %s: dynamicFilePath must be set if and only if DynamicFile is a backend mode", cluster.ClusterID)
+		}
+		if cluster.Kubeconfig == "" {
+			for _, mode := range cluster.BackendMode {
+				if mode == mapper.ModeACKConfigMap || mode == mapper.ModeCRD {
+					return cfg, fmt.Errorf("cluster %s: kubeconfig must be set for the %s backend mode", cluster.ClusterID, mode)
+				}
+			}
+		}
+	}
 
 	return cfg, nil
 }
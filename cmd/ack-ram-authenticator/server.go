@@ -26,6 +26,7 @@ import (
 	"k8s.io/sample-controller/pkg/signals"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -35,6 +36,9 @@ import (
 // DefaultPort is the default localhost port (chosen randomly).
 const DefaultPort = 21362
 
+// DefaultHealthPort is the default port of the health and metrics server.
+const DefaultHealthPort = 21363
+
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
 	Use:   "server",
@@ -51,7 +55,7 @@ var serverCmd = &cobra.Command{
 			logrus.Fatalf("%s", err)
 		}
 
-		httpServer := server.New(cfg, stopCh)
+		httpServer := server.New(cfg)
 		httpServer.Run(stopCh)
 	},
 }
@@ -74,6 +78,56 @@ func init() {
 		"State `directory` for generated certificate and private key (should be a hostPath mount).")
 	viper.BindPFlag("server.stateDir", serverCmd.Flags().Lookup("state-dir"))
 
+	serverCmd.Flags().String("tls-cert-file",
+		"",
+		"`path` of an existing serving certificate to use instead of the one generated in the state directory. Reloaded when it changes.")
+	viper.BindPFlag("server.tlsCertFile", serverCmd.Flags().Lookup("tls-cert-file"))
+
+	serverCmd.Flags().String("tls-key-file",
+		"",
+		"`path` of the private key of --tls-cert-file.")
+	viper.BindPFlag("server.tlsKeyFile", serverCmd.Flags().Lookup("tls-key-file"))
+
+	serverCmd.Flags().String("client-ca-file",
+		"",
+		"`path` of a PEM CA bundle. When set, clients must present a certificate signed by one of these CAs.")
+	viper.BindPFlag("server.clientCAFile", serverCmd.Flags().Lookup("client-ca-file"))
+
+	serverCmd.Flags().StringSlice("allowed-client-names",
+		[]string{},
+		"Glob patterns of the client certificate common names or DNS SANs accepted when --client-ca-file is set. Empty accepts any name.")
+	viper.BindPFlag("server.allowedClientNames", serverCmd.Flags().Lookup("allowed-client-names"))
+
+	serverCmd.Flags().String("webhook-client-cert-file",
+		"",
+		"`path` on the apiserver host of the client certificate written into the generated webhook kubeconfig.")
+	viper.BindPFlag("server.webhookClientCertFile", serverCmd.Flags().Lookup("webhook-client-cert-file"))
+
+	serverCmd.Flags().String("webhook-client-key-file",
+		"",
+		"`path` on the apiserver host of the client key written into the generated webhook kubeconfig.")
+	viper.BindPFlag("server.webhookClientKeyFile", serverCmd.Flags().Lookup("webhook-client-key-file"))
+
+	serverCmd.Flags().String("tls-min-version",
+		"1.2",
+		"Minimum TLS version accepted by the webhook listener: 1.2 or 1.3.")
+	viper.BindPFlag("server.tlsMinVersion", serverCmd.Flags().Lookup("tls-min-version"))
+
+	serverCmd.Flags().String("tls-max-version",
+		"",
+		"Maximum TLS version accepted by the webhook listener. Empty uses the highest supported version.")
+	viper.BindPFlag("server.tlsMaxVersion", serverCmd.Flags().Lookup("tls-max-version"))
+
+	serverCmd.Flags().StringSlice("tls-cipher-suites",
+		[]string{},
+		"Comma-separated list of TLS 1.2 cipher suites by IANA name. Empty uses Go's secure defaults.")
+	viper.BindPFlag("server.tlsCipherSuites", serverCmd.Flags().Lookup("tls-cipher-suites"))
+
+	serverCmd.Flags().StringSlice("tls-curve-preferences",
+		[]string{},
+		"Comma-separated list of key exchange curves: X25519, P256, P384, P521. Empty uses Go's defaults.")
+	viper.BindPFlag("server.tlsCurvePreferences", serverCmd.Flags().Lookup("tls-curve-preferences"))
+
 	serverCmd.Flags().StringP(
 		"bind",
 		"b",
@@ -83,9 +137,89 @@ func init() {
 
 	serverCmd.Flags().StringSlice("backend-mode",
 		[]string{mapper.ModeMountedFile},
-		fmt.Sprintf("Ordered list of backends to get mappings from. The first one that returns a matching mapping wins. Comma-delimited list of: %s", strings.Join(mapper.BackendModeChoices, ",")))
+		fmt.Sprintf("Ordered list of backends to get mappings from. See --mapper-chain-policy for how they are combined. Comma-delimited list of: %s", strings.Join(mapper.BackendModeChoices, ",")))
 	viper.BindPFlag("server.backendMode", serverCmd.Flags().Lookup("backend-mode"))
 
+	serverCmd.Flags().String("mapper-chain-policy",
+		mapper.ChainPolicyFirst,
+		"How the backends are combined. \"first\": the first backend that maps an identity wins. \"merge\": the first backend that maps an identity sets the username and the groups of all backends that map it are combined.")
+	viper.BindPFlag("server.mapperChainPolicy", serverCmd.Flags().Lookup("mapper-chain-policy"))
+
+	serverCmd.Flags().Duration("mapping-conflict-check-interval",
+		5*time.Minute,
+		"How often to compare the mappings of all backends for duplicate, conflicting and shadowed mappings. 0 disables the check.")
+	viper.BindPFlag("server.mappingConflictCheckInterval", serverCmd.Flags().Lookup("mapping-conflict-check-interval"))
+
+	serverCmd.Flags().StringSlice("explain-allowed-groups",
+		nil,
+		fmt.Sprintf("Groups allowed to call %s to explain how an ARN or token is mapped. The endpoint is disabled unless set.", server.ExplainPath))
+	viper.BindPFlag("server.explainAllowedGroups", serverCmd.Flags().Lookup("explain-allowed-groups"))
+
+	serverCmd.Flags().String("uid-strategy",
+		server.UIDStrategyUserID,
+		fmt.Sprintf("How the UID of a user is derived from its identity. One of: %s", strings.Join(server.UIDStrategyChoices, ",")))
+	viper.BindPFlag("server.uidStrategy", serverCmd.Flags().Lookup("uid-strategy"))
+
+	serverCmd.Flags().String("uid-template",
+		"",
+		"UID template of the \"template\" uid-strategy, with the variables of the username templates.")
+	viper.BindPFlag("server.uidTemplate", serverCmd.Flags().Lookup("uid-template"))
+
+	serverCmd.Flags().StringSlice("extra-keys",
+		server.DefaultExtraKeys,
+		fmt.Sprintf("Keys of the user extras returned to Kubernetes. Comma-delimited list of: %s", strings.Join(server.ExtraKeyChoices, ",")))
+	viper.BindPFlag("server.extraKeys", serverCmd.Flags().Lookup("extra-keys"))
+
+	serverCmd.Flags().String("deny-list-file",
+		"",
+		"File of deny rules checked before any backend, reloaded when it changes. Empty disables it.")
+	viper.BindPFlag("server.denyListFile", serverCmd.Flags().Lookup("deny-list-file"))
+
+	serverCmd.Flags().String("deny-list-configmap",
+		"",
+		"Name of a ConfigMap whose deny key holds deny rules checked before any backend. Empty disables it.")
+	viper.BindPFlag("server.denyListConfigMap", serverCmd.Flags().Lookup("deny-list-configmap"))
+
+	serverCmd.Flags().String("deny-list-configmap-namespace",
+		"kube-system",
+		"Namespace of the deny list ConfigMap.")
+	viper.BindPFlag("server.denyListConfigMapNamespace", serverCmd.Flags().Lookup("deny-list-configmap-namespace"))
+
+	serverCmd.Flags().Bool("deny-list-crd",
+		false,
+		"Check the deny rules of the RAMDenyRule objects before any backend.")
+	viper.BindPFlag("server.denyListCRD", serverCmd.Flags().Lookup("deny-list-crd"))
+
+	serverCmd.Flags().String("audit-log-path",
+		"",
+		"File to append a JSON line to for every authentication decision, or \"-\" for stdout. Empty disables the audit log.")
+	viper.BindPFlag("server.auditLogPath", serverCmd.Flags().Lookup("audit-log-path"))
+
+	serverCmd.Flags().Int("audit-log-max-size",
+		100,
+		"Size in megabytes after which the audit log is rotated. 0 disables rotation.")
+	viper.BindPFlag("server.auditLogMaxSize", serverCmd.Flags().Lookup("audit-log-max-size"))
+
+	serverCmd.Flags().Int("audit-log-max-backups",
+		5,
+		"Number of rotated audit logs to keep.")
+	viper.BindPFlag("server.auditLogMaxBackups", serverCmd.Flags().Lookup("audit-log-max-backups"))
+
+	serverCmd.Flags().String("audit-webhook-url",
+		"",
+		"URL to POST batches of audit events to as a JSON array. Empty disables the webhook.")
+	viper.BindPFlag("server.auditWebhookURL", serverCmd.Flags().Lookup("audit-webhook-url"))
+
+	serverCmd.Flags().Int("audit-webhook-batch-size",
+		100,
+		"Maximum number of audit events sent to the webhook in one request.")
+	viper.BindPFlag("server.auditWebhookBatchSize", serverCmd.Flags().Lookup("audit-webhook-batch-size"))
+
+	serverCmd.Flags().Duration("audit-webhook-batch-interval",
+		5*time.Second,
+		"Maximum time an audit event is buffered before it is sent to the webhook.")
+	viper.BindPFlag("server.auditWebhookBatchInterval", serverCmd.Flags().Lookup("audit-webhook-batch-interval"))
+
 	serverCmd.Flags().Int(
 		"port",
 		DefaultPort,
@@ -98,5 +232,125 @@ func init() {
 		"current region")
 	viper.BindPFlag("server.region", serverCmd.Flags().Lookup("region"))
 
+	serverCmd.Flags().StringSlice("audiences",
+		[]string{},
+		"TokenReview audiences accepted for tokens of this cluster. Reviews requesting other audiences are denied. Empty means audience agnostic.")
+	viper.BindPFlag("server.audiences", serverCmd.Flags().Lookup("audiences"))
+
+	serverCmd.Flags().Int("token-cache-size",
+		0,
+		"Maximum number of verified tokens to cache until they expire. A cached token keeps authenticating for up to 15 minutes after its access key is revoked. 0 disables the cache.")
+	viper.BindPFlag("server.tokenCacheSize", serverCmd.Flags().Lookup("token-cache-size"))
+
+	serverCmd.Flags().Duration("token-cache-negative-ttl",
+		10*time.Second,
+		"How long to cache tokens definitively rejected by STS.")
+	viper.BindPFlag("server.tokenCacheNegativeTTL", serverCmd.Flags().Lookup("token-cache-negative-ttl"))
+
+	serverCmd.Flags().Duration("token-clock-skew",
+		5*time.Minute,
+		"How far the signing time of a v1 token may be off the server's clock before it is rejected without calling STS.")
+	viper.BindPFlag("server.tokenClockSkew", serverCmd.Flags().Lookup("token-clock-skew"))
+
+	serverCmd.Flags().Int("nonce-store-size",
+		0,
+		"Maximum number of signature nonces of verified tokens remembered to reject replayed tokens. Once reached, the nonces expiring first are forgotten. 0 disables replay protection.")
+	viper.BindPFlag("server.nonceStoreSize", serverCmd.Flags().Lookup("nonce-store-size"))
+
+	serverCmd.Flags().Int("nonce-max-reuses",
+		0,
+		"How many times the client that first presented a token may present it again. Other clients may never present it again.")
+	viper.BindPFlag("server.nonceMaxReuses", serverCmd.Flags().Lookup("nonce-max-reuses"))
+
+	serverCmd.Flags().Duration("nonce-reuse-window",
+		0,
+		"How long after its first use a token may be reused. 0 allows reuses until the token expires.")
+	viper.BindPFlag("server.nonceReuseWindow", serverCmd.Flags().Lookup("nonce-reuse-window"))
+
+	serverCmd.Flags().Float64("client-address-rate-limit-qps",
+		0,
+		"Maximum TokenReviews per second accepted from the same client address, before their tokens are verified. 0 disables the limit.")
+	viper.BindPFlag("server.clientAddressRateLimitQPS", serverCmd.Flags().Lookup("client-address-rate-limit-qps"))
+
+	serverCmd.Flags().Int("client-address-rate-limit-burst",
+		20,
+		"Burst of TokenReviews accepted from the same client address.")
+	viper.BindPFlag("server.clientAddressRateLimitBurst", serverCmd.Flags().Lookup("client-address-rate-limit-burst"))
+
+	serverCmd.Flags().Float64("access-key-rate-limit-qps",
+		0,
+		"Maximum TokenReviews per second accepted for tokens verified to be signed with the same AccessKeyID, cached or not. 0 disables the limit.")
+	viper.BindPFlag("server.accessKeyRateLimitQPS", serverCmd.Flags().Lookup("access-key-rate-limit-qps"))
+
+	serverCmd.Flags().Int("access-key-rate-limit-burst",
+		10,
+		"Burst of TokenReviews accepted for tokens verified to be signed with the same AccessKeyID.")
+	viper.BindPFlag("server.accessKeyRateLimitBurst", serverCmd.Flags().Lookup("access-key-rate-limit-burst"))
+
+	serverCmd.Flags().Int("max-concurrent-sts-calls",
+		0,
+		"Maximum number of STS calls in flight. 0 means unlimited.")
+	viper.BindPFlag("server.maxConcurrentSTSCalls", serverCmd.Flags().Lookup("max-concurrent-sts-calls"))
+
+	serverCmd.Flags().StringSlice("sts-endpoints",
+		nil,
+		"STS endpoints (host[:port]) tried in order to verify tokens. Defaults to the VPC and public endpoints of the region, then the global endpoint.")
+	viper.BindPFlag("server.stsEndpoints", serverCmd.Flags().Lookup("sts-endpoints"))
+
+	serverCmd.Flags().Int("sts-max-retries",
+		2,
+		"How many times an STS call failing to connect is retried on the next endpoint.")
+	viper.BindPFlag("server.stsMaxRetries", serverCmd.Flags().Lookup("sts-max-retries"))
+
+	serverCmd.Flags().Duration("sts-retry-backoff",
+		100*time.Millisecond,
+		"Base of the jittered exponential backoff between the attempts of an STS call.")
+	viper.BindPFlag("server.stsRetryBackoff", serverCmd.Flags().Lookup("sts-retry-backoff"))
+
+	serverCmd.Flags().Int("sts-breaker-failures",
+		5,
+		"Consecutive failures of an STS endpoint that open its circuit breaker. 0 disables circuit breaking.")
+	viper.BindPFlag("server.stsBreakerFailures", serverCmd.Flags().Lookup("sts-breaker-failures"))
+
+	serverCmd.Flags().Duration("sts-breaker-cooldown",
+		30*time.Second,
+		"How long an STS endpoint with an open circuit breaker is tried after the other endpoints.")
+	viper.BindPFlag("server.stsBreakerCooldown", serverCmd.Flags().Lookup("sts-breaker-cooldown"))
+
+	serverCmd.Flags().Duration("shutdown-delay",
+		5*time.Second,
+		"How long to keep serving with failing readiness checks after receiving a termination signal, before draining.")
+	viper.BindPFlag("server.shutdownDelay", serverCmd.Flags().Lookup("shutdown-delay"))
+
+	serverCmd.Flags().Duration("shutdown-grace-period",
+		30*time.Second,
+		"How long to wait for in-flight requests to finish after receiving a termination signal.")
+	viper.BindPFlag("server.shutdownGracePeriod", serverCmd.Flags().Lookup("shutdown-grace-period"))
+
+	serverCmd.Flags().String("health-address",
+		"",
+		"IP Address to bind the plain HTTP health and metrics server to. Empty binds all interfaces.")
+	viper.BindPFlag("server.healthAddress", serverCmd.Flags().Lookup("health-address"))
+
+	serverCmd.Flags().Int("health-port",
+		DefaultHealthPort,
+		"Port of the plain HTTP server serving /metrics, /healthz, /readyz and /livez. 0 disables it.")
+	viper.BindPFlag("server.healthPort", serverCmd.Flags().Lookup("health-port"))
+
+	serverCmd.Flags().Bool("enable-pprof",
+		false,
+		"Serve /debug/pprof on the health and metrics server.")
+	viper.BindPFlag("server.enablePprof", serverCmd.Flags().Lookup("enable-pprof"))
+
+	serverCmd.Flags().String("ecs-endpoint",
+		"",
+		"ECS API endpoint used to resolve the ECS template variables. Defaults to the VPC endpoint of the region.")
+	viper.BindPFlag("server.ecsEndpoint", serverCmd.Flags().Lookup("ecs-endpoint"))
+
+	serverCmd.Flags().Duration("ecs-instance-cache-ttl",
+		15*time.Minute,
+		"How long to cache ECS instances resolved for the ECS template variables. 0 disables the cache.")
+	viper.BindPFlag("server.ecsInstanceCacheTTL", serverCmd.Flags().Lookup("ecs-instance-cache-ttl"))
+
 	rootCmd.AddCommand(serverCmd)
 }
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit writes a structured record of every authentication decision,
+// separately from the debug logs.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+
+	// StdoutPath is the log path that writes events to stdout.
+	StdoutPath = "-"
+)
+
+// Event is the audit record of one TokenReview, or of one call to the explain
+// endpoint, whose Path is then set. The identity fields are empty when the
+// token could not be verified or its account is scrubbed, and ClusterID is
+// only set when the server serves several clusters.
+type Event struct {
+	Time         time.Time `json:"timestamp"`
+	Client       string    `json:"client"`
+	Path         string    `json:"path,omitempty"`
+	ClusterID    string    `json:"clusterID,omitempty"`
+	TokenVersion string    `json:"tokenVersion,omitempty"`
+	AccessKeyID  string    `json:"accessKeyID,omitempty"`
+	AccountID    string    `json:"accountID,omitempty"`
+	ARN          string    `json:"arn,omitempty"`
+	// Backends are the backends that mapped the identity.
+	Backends []string `json:"backends,omitempty"`
+	Username string   `json:"username,omitempty"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Decision string   `json:"decision"`
+	// Result is the result label of the authenticate latency metric.
+	Result string `json:"result"`
+	// Reason is why the request was denied.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink receives audit events. Write must not block the request for long.
+type Sink interface {
+	Write(event *Event)
+	// Close flushes buffered events and releases the sink.
+	Close() error
+}
+
+// Options configures the sinks of NewSink.
+type Options struct {
+	// LogPath is the file events are written to as JSON lines, or StdoutPath.
+	LogPath string
+	// LogMaxSize is the size in megabytes after which the log file is
+	// rotated. 0 disables rotation.
+	LogMaxSize int
+	// LogMaxBackups is the number of rotated log files kept.
+	LogMaxBackups int
+
+	// WebhookURL is the URL batches of events are POSTed to as a JSON array.
+	WebhookURL string
+	// WebhookBatchSize is the most events sent in one request.
+	WebhookBatchSize int
+	// WebhookBatchInterval is the longest an event is buffered before it is
+	// sent.
+	WebhookBatchInterval time.Duration
+	// WebhookBufferSize is the most events buffered for the webhook. Events
+	// are dropped while the buffer is full.
+	WebhookBufferSize int
+}
+
+// NewSink returns a sink writing to each configured destination, or nil if
+// none is configured.
+func NewSink(options Options) (Sink, error) {
+	var sinks multiSink
+	switch options.LogPath {
+	case "":
+	case StdoutPath:
+		sinks = append(sinks, newWriterSink(nopCloser{os.Stdout}))
+	default:
+		file, err := newRotatingFile(options.LogPath, int64(options.LogMaxSize)*1024*1024, options.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newWriterSink(file))
+	}
+	if options.WebhookURL != "" {
+		webhook, err := newWebhookSink(options.WebhookURL, options.WebhookBatchSize, options.WebhookBatchInterval, options.WebhookBufferSize)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, webhook)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return sinks, nil
+}
+
+type multiSink []Sink
+
+func (s multiSink) Write(event *Event) {
+	for _, sink := range s {
+		sink.Write(event)
+	}
+}
+
+func (s multiSink) Close() error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// writerSink writes each event as a JSON line.
+type writerSink struct {
+	lock sync.Mutex
+	out  io.WriteCloser
+}
+
+func newWriterSink(out io.WriteCloser) *writerSink {
+	return &writerSink{out: out}
+}
+
+func (s *writerSink) Write(event *Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithError(err).Error("could not marshal audit event")
+		return
+	}
+	line = append(line, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.out.Write(line); err != nil {
+		logrus.WithError(err).Error("could not write audit event")
+		dropped("log", 1)
+	}
+}
+
+func (s *writerSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.out.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func dropped(sink string, n int) {
+	if metrics.Initialized() {
+		metrics.Get().AuditEventsDropped.WithLabelValues(sink).Add(float64(n))
+	}
+}
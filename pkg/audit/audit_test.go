@@ -0,0 +1,170 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewSink(Options{LogPath: path})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	sink.Write(&Event{Username: "alice", Decision: DecisionAllow, Result: "success"})
+	sink.Write(&Event{Decision: DecisionDeny, Result: "invalid_token", Reason: "expired"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+
+	events := readEvents(t, path)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events but got %d", len(events))
+	}
+	if events[0].Username != "alice" || events[1].Reason != "expired" {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	file, err := newRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+	}
+	file.Close()
+
+	expected := map[string]string{
+		path:                "fourth\n",
+		backupPath(path, 1): "third\n",
+		backupPath(path, 2): "second\n",
+		backupPath(path, 3): "",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(name)
+		if content == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("expected %s not to exist", name)
+			}
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("expected %s to contain %q but got %q", name, content, data)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Event
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("invalid batch: %v", err)
+		}
+		lock.Lock()
+		batches = append(batches, batch)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(Options{WebhookURL: server.URL, WebhookBatchSize: 2, WebhookBatchInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	for _, username := range []string{"a", "b", "c"} {
+		sink.Write(&Event{Username: username, Decision: DecisionAllow})
+	}
+	// Close sends the last, partial batch
+	if err := sink.Close(); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("expected batches of 2 and 1 events but got %+v", batches)
+	}
+	if batches[1][0].Username != "c" {
+		t.Errorf("expected the last batch to contain c but got %+v", batches[1])
+	}
+
+	// handlers still running after shutdown may write to the closed sink
+	sink.Write(&Event{Username: "d", Decision: DecisionAllow})
+	if err := sink.Close(); err != nil {
+		t.Errorf("expected closing again to succeed but got %q", err)
+	}
+	if len(batches) != 2 {
+		t.Errorf("expected the event written after Close to be dropped but got %+v", batches)
+	}
+}
+
+func TestWebhookSinkInvalidURL(t *testing.T) {
+	if _, err := NewSink(Options{WebhookURL: "not a url"}); err == nil {
+		t.Errorf("expected an error for an invalid webhook URL")
+	}
+}
+
+func TestNoSink(t *testing.T) {
+	sink, err := NewSink(Options{})
+	if err != nil || sink != nil {
+		t.Errorf("expected no sink and no error but got %v, %v", sink, err)
+	}
+}
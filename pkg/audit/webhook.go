@@ -0,0 +1,152 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebhookBatchSize     = 100
+	defaultWebhookBatchInterval = 5 * time.Second
+	defaultWebhookBufferSize    = 10000
+)
+
+// webhookSink buffers events and POSTs them in batches from a single
+// goroutine. A batch that cannot be sent is dropped, so a slow or failing
+// collector never delays authentication. Events written after Close are
+// dropped too.
+type webhookSink struct {
+	url       string
+	client    *http.Client
+	batchSize int
+	interval  time.Duration
+	// lock guards closed and the send on events against closing them
+	lock   sync.RWMutex
+	closed bool
+	events chan *Event
+	done   chan struct{}
+}
+
+func newWebhookSink(rawURL string, batchSize int, interval time.Duration, bufferSize int) (*webhookSink, error) {
+	if _, err := url.ParseRequestURI(rawURL); err != nil {
+		return nil, fmt.Errorf("invalid audit webhook URL: %v", err)
+	}
+	if batchSize <= 0 {
+		batchSize = defaultWebhookBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultWebhookBatchInterval
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultWebhookBufferSize
+	}
+	s := &webhookSink{
+		url:       rawURL,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		batchSize: batchSize,
+		interval:  interval,
+		events:    make(chan *Event, bufferSize),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *webhookSink) Write(event *Event) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		dropped("webhook", 1)
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		dropped("webhook", 1)
+	}
+}
+
+// Close sends the buffered events and stops the sink.
+func (s *webhookSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.lock.Unlock()
+	<-s.done
+	return nil
+}
+
+func (s *webhookSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, s.batchSize)
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				s.send(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (s *webhookSink) send(batch []*Event) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.post(batch); err != nil {
+		logrus.WithError(err).WithField("events", len(batch)).Error("could not send audit events")
+		dropped("webhook", len(batch))
+	}
+}
+
+func (s *webhookSink) post(batch []*Event) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestMatchesNamePatterns(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "kube-apiserver"},
+		DNSNames: []string{"apiserver.cluster-a.example.com"},
+	}
+	tests := []struct {
+		patterns []string
+		matched  bool
+	}{
+		{[]string{"kube-apiserver"}, true},
+		{[]string{"kube-*"}, true},
+		{[]string{"*.cluster-a.example.com"}, true},
+		{[]string{"other", "apiserver.*.example.com"}, true},
+		{[]string{"*.cluster-b.example.com"}, false},
+		{[]string{"kube-apiserver-kubelet-client"}, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if matched := MatchesNamePatterns(cert, test.patterns); matched != test.matched {
+			t.Errorf("patterns %v: expected matched to be %v but was %v", test.patterns, test.matched, matched)
+		}
+	}
+}
+
+func TestValidateNamePatterns(t *testing.T) {
+	if err := ValidateNamePatterns([]string{"kube-*", "*.example.com"}); err != nil {
+		t.Errorf("expected error to be nil was %q", err)
+	}
+	if err := ValidateNamePatterns([]string{"kube-[apiserver"}); err == nil {
+		t.Error("expected an invalid pattern to be rejected")
+	}
+}
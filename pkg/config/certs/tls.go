@@ -0,0 +1,169 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions maps the accepted spellings of a TLS version to its value.
+var tlsVersions = map[string]uint16{
+	"1.0":          tls.VersionTLS10,
+	"1.1":          tls.VersionTLS11,
+	"1.2":          tls.VersionTLS12,
+	"1.3":          tls.VersionTLS13,
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "1.0",
+	tls.VersionTLS11: "1.1",
+	tls.VersionTLS12: "1.2",
+	tls.VersionTLS13: "1.3",
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// TLSPolicy is the protocol versions, cipher suites and curves accepted by a
+// TLS listener. Zero values keep Go's defaults.
+type TLSPolicy struct {
+	MinVersion       uint16
+	MaxVersion       uint16
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+
+	cipherSuiteNames []string
+	curveNames       []string
+}
+
+// ParseTLSPolicy validates the configured TLS policy. Versions are written as
+// "1.2" or "VersionTLS12", cipher suites by their IANA names and curves as
+// X25519, P256, P384 or P521.
+func ParseTLSPolicy(minVersion, maxVersion string, cipherSuites, curves []string) (TLSPolicy, error) {
+	var policy TLSPolicy
+	var err error
+
+	if policy.MinVersion, err = parseTLSVersion(minVersion); err != nil {
+		return policy, err
+	}
+	if policy.MaxVersion, err = parseTLSVersion(maxVersion); err != nil {
+		return policy, err
+	}
+	if policy.MinVersion != 0 && policy.MaxVersion != 0 && policy.MinVersion > policy.MaxVersion {
+		return policy, fmt.Errorf("tls min version %s is greater than max version %s", minVersion, maxVersion)
+	}
+
+	if len(cipherSuites) > 0 {
+		if policy.MinVersion == tls.VersionTLS13 {
+			return policy, fmt.Errorf("tls cipher suites cannot be configured when the min version is 1.3")
+		}
+		suites := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range cipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return policy, fmt.Errorf("unsupported or insecure tls cipher suite %q", name)
+			}
+			policy.CipherSuites = append(policy.CipherSuites, id)
+			policy.cipherSuiteNames = append(policy.cipherSuiteNames, name)
+		}
+	}
+
+	for _, name := range curves {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return policy, fmt.Errorf("unsupported tls curve %q", name)
+		}
+		policy.CurvePreferences = append(policy.CurvePreferences, id)
+		policy.curveNames = append(policy.curveNames, name)
+	}
+
+	return policy, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tls version %q", version)
+	}
+	return v, nil
+}
+
+// Apply sets the policy on a tls.Config.
+func (p TLSPolicy) Apply(cfg *tls.Config) {
+	if p.MinVersion != 0 {
+		cfg.MinVersion = p.MinVersion
+	}
+	if p.MaxVersion != 0 {
+		cfg.MaxVersion = p.MaxVersion
+	}
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites = p.CipherSuites
+	}
+	if len(p.CurvePreferences) > 0 {
+		cfg.CurvePreferences = p.CurvePreferences
+	}
+}
+
+// MinVersionName returns the minimum version, or "default".
+func (p TLSPolicy) MinVersionName() string {
+	return versionName(p.MinVersion)
+}
+
+// MaxVersionName returns the maximum version, or "default".
+func (p TLSPolicy) MaxVersionName() string {
+	return versionName(p.MaxVersion)
+}
+
+// CipherSuiteNames returns the comma separated cipher suites, or "default".
+func (p TLSPolicy) CipherSuiteNames() string {
+	return joinOrDefault(p.cipherSuiteNames)
+}
+
+// CurveNames returns the comma separated curves, or "default".
+func (p TLSPolicy) CurveNames() string {
+	return joinOrDefault(p.curveNames)
+}
+
+func versionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return "default"
+}
+
+func joinOrDefault(names []string) string {
+	if len(names) == 0 {
+		return "default"
+	}
+	return strings.Join(names, ",")
+}
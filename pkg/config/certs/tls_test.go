@@ -0,0 +1,75 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseTLSPolicy(t *testing.T) {
+	policy, err := ParseTLSPolicy("VersionTLS12", "1.3",
+		[]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		[]string{"X25519", "P256"})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+
+	cfg := &tls.Config{}
+	policy.Apply(cfg)
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS13 {
+		t.Errorf("expected versions 1.2-1.3 but got %x-%x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	if len(cfg.CipherSuites) != 1 || cfg.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites %v", cfg.CipherSuites)
+	}
+	if len(cfg.CurvePreferences) != 2 || cfg.CurvePreferences[0] != tls.X25519 {
+		t.Errorf("unexpected curves %v", cfg.CurvePreferences)
+	}
+	if policy.MinVersionName() != "1.2" || policy.CurveNames() != "X25519,P256" {
+		t.Errorf("unexpected policy names %s, %s", policy.MinVersionName(), policy.CurveNames())
+	}
+
+	policy, err = ParseTLSPolicy("", "", nil, nil)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if policy.MaxVersionName() != "default" || policy.CipherSuiteNames() != "default" {
+		t.Errorf("expected an empty policy to keep the defaults")
+	}
+}
+
+func TestParseTLSPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		min, max     string
+		cipherSuites []string
+		curves       []string
+	}{
+		{name: "unknown version", min: "1.4"},
+		{name: "min above max", min: "1.3", max: "1.2"},
+		{name: "unknown cipher suite", cipherSuites: []string{"TLS_FOO"}},
+		{name: "insecure cipher suite", cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{name: "cipher suites with tls 1.3", min: "1.3", cipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		{name: "unknown curve", curves: []string{"P224"}},
+	}
+	for _, test := range tests {
+		if _, err := ParseTLSPolicy(test.min, test.max, test.cipherSuites, test.curves); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
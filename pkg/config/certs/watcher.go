@@ -0,0 +1,158 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+// reloadDelay batches the events of a certificate rotation, which usually
+// writes the certificate and the key separately, into a single reload.
+const reloadDelay = 500 * time.Millisecond
+
+// CertificateWatcher serves a keypair from disk and reloads it when the files
+// change. The parent directories are watched rather than the files so that
+// atomic renames and Secret volume symlink swaps are noticed as well.
+type CertificateWatcher struct {
+	certPath string
+	keyPath  string
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+// NewCertificateWatcher loads the keypair and returns a watcher serving it.
+func NewCertificateWatcher(certPath, keyPath string) (*CertificateWatcher, error) {
+	w := &CertificateWatcher{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// GetCertificate returns the current keypair. It is meant to be used as
+// tls.Config.GetCertificate.
+func (w *CertificateWatcher) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.cert, nil
+}
+
+// Reload reads the keypair from disk. The current keypair is kept if the
+// files cannot be loaded.
+func (w *CertificateWatcher) Reload() error {
+	cert, err := loadKeyPair(w.certPath, w.keyPath)
+	if err != nil {
+		observeReload(metrics.ReloadFailure)
+		return err
+	}
+
+	w.mutex.Lock()
+	w.cert = cert
+	w.mutex.Unlock()
+
+	observeReload(metrics.ReloadSuccess)
+	if metrics.Initialized() {
+		metrics.Get().CertificateNotAfter.Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	logrus.WithFields(logrus.Fields{
+		"certPath": w.certPath,
+		"notAfter": cert.Leaf.NotAfter,
+	}).Info("loaded serving certificate")
+	return nil
+}
+
+// Run watches the keypair until stopCh is closed. It must be called in a
+// goroutine.
+func (w *CertificateWatcher) Run(stopCh <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := map[string]bool{
+		filepath.Dir(w.certPath): true,
+		filepath.Dir(w.keyPath):  true,
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			reload.Reset(reloadDelay)
+		case <-reload.C:
+			if err := w.Reload(); err != nil {
+				logrus.WithError(err).Error("could not reload serving certificate, keeping the previous one")
+			}
+		case err := <-watcher.Errors:
+			logrus.WithError(err).Error("serving certificate watcher error")
+		}
+	}
+}
+
+func loadKeyPair(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("no certificate found in " + certPath)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func observeReload(result string) {
+	if !metrics.Initialized() {
+		return
+	}
+	metrics.Get().CertificateReloads.WithLabelValues(result).Inc()
+	if result == metrics.ReloadSuccess {
+		metrics.Get().CertificateLastReload.Set(1)
+	} else {
+		metrics.Get().CertificateLastReload.Set(0)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKeyPair(t *testing.T, certPath, keyPath, hostname string) []byte {
+	certBytes, keyBytes, err := selfSignedCertificate("127.0.0.1", hostname, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dumpPEM(certPath, 0600, "CERTIFICATE", certBytes); err != nil {
+		t.Fatal(err)
+	}
+	if err := dumpPEM(keyPath, 0600, "RSA PRIVATE KEY", keyBytes); err != nil {
+		t.Fatal(err)
+	}
+	return certBytes
+}
+
+func servedCertificate(t *testing.T, w *CertificateWatcher) []byte {
+	cert, err := w.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestCertificateWatcherReloads(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	first := writeKeyPair(t, certPath, keyPath, "first.example.com")
+	w, err := NewCertificateWatcher(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(servedCertificate(t, w), first) {
+		t.Fatal("expected the initial certificate to be served")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go w.Run(stopCh)
+	// give the watcher time to register the directory
+	time.Sleep(100 * time.Millisecond)
+
+	second := writeKeyPair(t, certPath, keyPath, "second.example.com")
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(servedCertificate(t, w), second) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the rotated certificate to be served")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCertificateWatcherKeepsCertificateOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	first := writeKeyPair(t, certPath, keyPath, "first.example.com")
+	w, err := NewCertificateWatcher(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil {
+		t.Error("expected reloading an invalid certificate to fail")
+	}
+	if !bytes.Equal(servedCertificate(t, w), first) {
+		t.Error("expected the previous certificate to be kept")
+	}
+}
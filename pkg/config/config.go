@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/certs"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/kubeconfig"
@@ -38,6 +39,17 @@ func (c *Config) ServerURL() string {
 	return u.String()
 }
 
+// ClusterServerURL returns the URL to connect to this server for clusterID,
+// which only accepts tokens generated for that cluster.
+func (c *Config) ClusterServerURL(clusterID string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   c.ServerAddr(),
+		Path:   "/authenticate/" + clusterID,
+	}
+	return u.String()
+}
+
 // ServerAddr returns the host and port clients should use for server endpoint.
 func (c *Config) ServerAddr() string {
 	return net.JoinHostPort(c.Hostname, strconv.Itoa(c.HostPort))
@@ -48,6 +60,11 @@ func (c *Config) ListenAddr() string {
 	return net.JoinHostPort(c.Address, strconv.Itoa(c.HostPort))
 }
 
+// HealthListenAddr returns the address the plain HTTP health and metrics server listens on
+func (c *Config) HealthListenAddr() string {
+	return net.JoinHostPort(c.HealthAddress, strconv.Itoa(c.HealthPort))
+}
+
 // GenerateFiles will generate the certificate and private key and then create the kubeconfig
 func (c *Config) GenerateFiles() error {
 	// load or generate a certificate+private key
@@ -62,22 +79,73 @@ func (c *Config) GenerateFiles() error {
 	return nil
 }
 
+// GenerateWebhookKubeconfig writes the webhook kubeconfig. A multi-cluster
+// server writes one per cluster, each with the URL of its cluster.
 func (c *Config) GenerateWebhookKubeconfig() error {
 	cert, err := certs.LoadX509KeyPair(c.CertPath(), c.KeyPath())
 	if err != nil {
 		return fmt.Errorf("failed to load an existing certificate: %v", err)
 	}
 
-	return kubeconfig.CreateWebhookKubeconfig(cert, c.GenerateKubeconfigPath, c.ServerURL())
+	if len(c.Clusters) == 0 {
+		return kubeconfig.CreateWebhookKubeconfig(cert, c.GenerateKubeconfigPath, c.ServerURL(), c.WebhookClientCertFile, c.WebhookClientKeyFile)
+	}
+	for _, cluster := range c.ClusterConfigs() {
+		if err := kubeconfig.CreateWebhookKubeconfig(cert, cluster.GenerateKubeconfigPath, c.ClusterServerURL(cluster.ClusterID), c.WebhookClientCertFile, c.WebhookClientKeyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClusterConfigs returns the configuration of each cluster served: the
+// server's own ClusterID, if set, followed by Clusters.
+func (c *Config) ClusterConfigs() []Config {
+	var configs []Config
+	if c.ClusterID != "" {
+		configs = append(configs, *c)
+	}
+	for _, cluster := range c.Clusters {
+		configs = append(configs, c.forCluster(cluster))
+	}
+	return configs
+}
+
+func (c *Config) forCluster(cluster ClusterConfig) Config {
+	cfg := *c
+	cfg.ClusterID = cluster.ClusterID
+	cfg.Audiences = cluster.Audiences
+	cfg.BackendMode = cluster.BackendMode
+	cfg.RoleMappings = cluster.RoleMappings
+	cfg.UserMappings = cluster.UserMappings
+	cfg.AutoMappedAlibabaCloudAccounts = cluster.AutoMappedAlibabaCloudAccounts
+	cfg.DynamicFilePath = cluster.DynamicFilePath
+	cfg.ScrubbedAliyunAccounts = cluster.ScrubbedAliyunAccounts
+	cfg.Clusters = nil
+	// a cluster never reads the mappings of the server's own cluster
+	cfg.Master = ""
+	cfg.Kubeconfig = cluster.Kubeconfig
+	cfg.GenerateKubeconfigPath = cluster.GenerateKubeconfigPath
+	if cfg.GenerateKubeconfigPath == "" {
+		ext := filepath.Ext(c.GenerateKubeconfigPath)
+		cfg.GenerateKubeconfigPath = strings.TrimSuffix(c.GenerateKubeconfigPath, ext) + "-" + cluster.ClusterID + ext
+	}
+	return cfg
 }
 
 // CertPath returns the path to the pem file containing the certificate
 func (c *Config) CertPath() string {
+	if c.TLSCertFile != "" {
+		return c.TLSCertFile
+	}
 	return filepath.Join(c.StateDir, "cert.pem")
 }
 
 // KeyPath returns the path to the pem file containing the private key
 func (c *Config) KeyPath() string {
+	if c.TLSKeyFile != "" {
+		return c.TLSKeyFile
+	}
 	return filepath.Join(c.StateDir, "key.pem")
 }
 
@@ -93,5 +161,13 @@ func (c *Config) CertOpts() certs.CertificateOptions {
 
 // GetOrCreateCertificate will create a certificate if it cannot find one based on the config
 func (c *Config) GetOrCreateX509KeyPair() (*tls.Certificate, error) {
+	if c.TLSCertFile != "" {
+		// a provided keypair is never generated
+		cert, err := certs.LoadX509KeyPair(c.CertPath(), c.KeyPath())
+		if err == nil && cert == nil {
+			err = fmt.Errorf("tls certificate %s or key %s does not exist", c.CertPath(), c.KeyPath())
+		}
+		return cert, err
+	}
 	return certs.GetOrCreateX509KeyPair(c.CertOpts())
 }
@@ -26,19 +26,32 @@ import (
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/certs"
 )
 
+// WebhookTokenReviewVersion is the TokenReview version the apiserver should be
+// configured with via `--authentication-token-webhook-version`. The server
+// still answers authentication.k8s.io/v1beta1 reviews for older apiservers.
+const WebhookTokenReviewVersion = "v1"
+
 type KubeconfigParams struct {
 	ServerURL                  string
 	CertificateAuthorityBase64 string
 	Token                      string
+	// ClientCertificatePath and ClientKeyPath are the paths, on the apiserver
+	// host, of the client certificate presented to a server requiring mutual TLS.
+	ClientCertificatePath string
+	ClientKeyPath         string
 }
 
-// CreateWebhookKubeconfig will create a kubeconfig for the webhook server
-func CreateWebhookKubeconfig(cert *tls.Certificate, kubeconfigPath, serverURL string) error {
+// CreateWebhookKubeconfig will create a kubeconfig for the webhook server.
+// clientCertPath and clientKeyPath are optional and set the apiserver client
+// certificate when the server requires one.
+func CreateWebhookKubeconfig(cert *tls.Certificate, kubeconfigPath, serverURL, clientCertPath, clientKeyPath string) error {
 	logrus.WithField("kubeconfigPath", kubeconfigPath).Info("writing webhook kubeconfig file")
 
 	return KubeconfigParams{
 		ServerURL:                  serverURL,
 		CertificateAuthorityBase64: certs.CertToPEMBase64(cert.Certificate[0]),
+		ClientCertificatePath:      clientCertPath,
+		ClientKeyPath:              clientKeyPath,
 	}.WriteKubeconfig(kubeconfigPath, webhookKubeconfigTemplate)
 }
 
@@ -53,6 +66,8 @@ func (p KubeconfigParams) WriteKubeconfig(outputPath string, t *template.Templat
 
 var webhookKubeconfigTemplate = template.Must(
 	template.New("webhook.kubeconfig").Option("missingkey=error").Parse(`
+# use together with --authentication-token-webhook-version=` + WebhookTokenReviewVersion + `
+# (authentication.k8s.io/v1beta1 TokenReviews are accepted as well).
 clusters:
   - name: ack-ram-authenticator
     cluster:
@@ -61,6 +76,11 @@ clusters:
 # user refers to the API server client
 users:
   - name: apiserver
+{{- if .ClientCertificatePath}}
+    user:
+      client-certificate: {{.ClientCertificatePath}}
+      client-key: {{.ClientKeyPath}}
+{{- end}}
 current-context: webhook
 contexts:
 - name: webhook
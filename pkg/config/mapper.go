@@ -1,8 +1,12 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/template"
 )
 
 // Validate returns an error if the RoleMapping is not valid after being unmarshaled
@@ -14,7 +18,7 @@ func (m *RoleMapping) Validate() error {
 	if m.RoleARN == "" {
 		return fmt.Errorf("One of rolearn must be supplied")
 	}
-	return nil
+	return ValidateTemplates(m.Username, m.Groups)
 }
 
 // Matches returns true if the supplied ARN or SSO settings matches
@@ -39,6 +43,20 @@ func (m *UserMapping) Validate() error {
 		return fmt.Errorf("Value for userarn must be supplied")
 	}
 
+	return ValidateTemplates(m.Username, m.Groups)
+}
+
+// ValidateTemplates returns an error if the username or one of the groups is
+// not a valid template.
+func ValidateTemplates(username string, groups []string) error {
+	if err := template.Validate(username); err != nil {
+		return fmt.Errorf("invalid username template %q: %v", username, err)
+	}
+	for _, group := range groups {
+		if err := template.Validate(group); err != nil {
+			return fmt.Errorf("invalid group template %q: %v", group, err)
+		}
+	}
 	return nil
 }
 
@@ -52,3 +70,68 @@ func (m *UserMapping) Matches(subject string) bool {
 func (m *UserMapping) Key() string {
 	return m.UserARN
 }
+
+// DefaultAccountUsername is the username of auto-mapped accounts that do not
+// set one, the ARN of the identity.
+const DefaultAccountUsername = "{{CanonicalARN}}"
+
+// Validate returns an error if the AccountMapping is not valid after being unmarshaled
+func (m *AccountMapping) Validate() error {
+	if m == nil {
+		return fmt.Errorf("AccountMapping is nil")
+	}
+
+	if m.AccountID == "" {
+		return fmt.Errorf("Value for accountid must be supplied")
+	}
+
+	return ValidateTemplates(m.Username, m.Groups)
+}
+
+// IdentityMapping returns the username and groups of the account's
+// identities.
+func (m *AccountMapping) IdentityMapping() *IdentityMapping {
+	username := m.Username
+	if username == "" {
+		username = DefaultAccountUsername
+	}
+	groups := m.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return &IdentityMapping{
+		Name:     m.AccountID,
+		Username: username,
+		Groups:   groups,
+	}
+}
+
+// UnmarshalJSON accepts either an account ID or an object.
+func (m *AccountMapping) UnmarshalJSON(data []byte) error {
+	var accountID string
+	if err := json.Unmarshal(data, &accountID); err == nil {
+		*m = AccountMapping{AccountID: accountID}
+		return nil
+	}
+	// the alias has no UnmarshalJSON method
+	type accountMapping AccountMapping
+	return json.Unmarshal(data, (*accountMapping)(m))
+}
+
+// MarshalYAML writes accounts without a username or groups as just their ID,
+// the format older versions read.
+func (m AccountMapping) MarshalYAML() (interface{}, error) {
+	if m.Username == "" && len(m.Groups) == 0 {
+		return m.AccountID, nil
+	}
+	type accountMapping AccountMapping
+	return accountMapping(m), nil
+}
+
+// AccountMappingDecodeHook lets viper decode account IDs into AccountMappings.
+func AccountMappingDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(AccountMapping{}) {
+		return AccountMapping{AccountID: data.(string)}, nil
+	}
+	return data, nil
+}
@@ -16,9 +16,15 @@ limitations under the License.
 
 package config
 
+import "time"
+
 type IdentityMapping struct {
 	IdentityARN string
 
+	// Name names the mapping that matched: the name of its Kubernetes object,
+	// or its role or user ARN, or the account ID of an account mapping.
+	Name string
+
 	// Username is the username pattern that this instances assuming this
 	// role will have in Kubernetes.
 	Username string
@@ -68,6 +74,23 @@ type UserMapping struct {
 	Groups []string
 }
 
+// AccountMapping auto-maps the RAM identities of an Alibaba Cloud account
+// that no backend maps explicitly. Username and Groups are templates like
+// those of RoleMapping; Username defaults to "{{CanonicalARN}}". In
+// configuration an account can also be written as just its ID.
+type AccountMapping struct {
+	// AccountID is the Alibaba Cloud account ID.
+	AccountID string `json:"accountid" yaml:"accountid"`
+
+	// Username is the username pattern identities of this account will have
+	// in Kubernetes.
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+
+	// Groups is a list of Kubernetes groups identities of this account will
+	// authenticate as.
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
 // Config specifies the configuration for a ack-ram-authenticator server
 type Config struct {
 	// ClusterID is a unique-per-cluster identifier for your
@@ -97,6 +120,35 @@ type Config struct {
 	// server webhook config]uration doesn't change on restart.
 	StateDir string
 
+	// TLSCertFile and TLSKeyFile optionally point to an existing serving
+	// keypair, e.g. in a mounted Secret, used instead of the one generated in
+	// StateDir. The files are watched and reloaded when they change.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// ClientCAFile is a PEM bundle of CAs. When set, clients must present a
+	// certificate signed by one of them, which the apiserver does with the
+	// client certificate configured in the webhook kubeconfig.
+	ClientCAFile string
+	// AllowedClientNames optionally restricts the accepted client
+	// certificates to those whose common name or a DNS SAN matches one of
+	// these glob patterns.
+	AllowedClientNames []string
+	// WebhookClientCertFile and WebhookClientKeyFile are written into the
+	// generated webhook kubeconfig as the apiserver's client certificate.
+	// They are paths on the apiserver host.
+	WebhookClientCertFile string
+	WebhookClientKeyFile  string
+
+	// TLSMinVersion and TLSMaxVersion bound the TLS versions accepted by the
+	// webhook listener, written as "1.2" or "VersionTLS12".
+	TLSMinVersion string
+	TLSMaxVersion string
+	// TLSCipherSuites restricts the TLS 1.2 cipher suites, by IANA name.
+	TLSCipherSuites []string
+	// TLSCurvePreferences restricts the key exchange curves: X25519, P256, P384, P521.
+	TLSCurvePreferences []string
+
 	// RoleMappings is a list of mappings from Alibaba Cloud RAM Role to
 	// Kubernetes username + groups.
 	RoleMappings []RoleMapping
@@ -107,7 +159,7 @@ type Config struct {
 
 	// AutoMappedAlibabaCloudAccounts is a list of Alibaba Cloud accounts that are allowed without an explicit user/role mapping.
 	// RAM ARN from these accounts automatically maps to the Kubernetes username.
-	AutoMappedAlibabaCloudAccounts []string
+	AutoMappedAlibabaCloudAccounts []AccountMapping
 
 	// ScrubbedAliyunAccounts is a list of  accounts that the role ARNs and uids
 	// are scrubbed from server log statements
@@ -129,6 +181,174 @@ type Config struct {
 	// BackendMode is an ordered list of backends to get mappings from. Comma-delimited list of: MountedFile,ACKConfigMap,CRD
 	BackendMode []string
 
+	// MapperChainPolicy is how the BackendMode backends are combined: "first"
+	// uses the first backend that maps an identity, "merge" takes the username
+	// of the first one and the groups of all of them.
+	MapperChainPolicy string
+
+	// MappingConflictCheckInterval is how often the mappings of all backends
+	// are compared for duplicate, conflicting and shadowed mappings. 0
+	// disables the check.
+	MappingConflictCheckInterval time.Duration
+
+	// ExplainAllowedGroups are the groups allowed to call the explain
+	// endpoint. The endpoint is disabled when empty.
+	ExplainAllowedGroups []string
+
+	// UIDStrategy is how the UID of a user is derived from its identity:
+	// "userid", "canonical-arn-hash" or "template".
+	UIDStrategy string
+	// UIDTemplate is the UID template of the "template" UIDStrategy.
+	UIDTemplate string
+	// ExtraKeys are the keys of UserInfo.Extra returned for a user.
+	ExtraKeys []string
+
+	// AuditLogPath is the file a JSON line is appended to for every
+	// TokenReview, or "-" for stdout. Empty disables the audit log.
+	AuditLogPath string
+	// AuditLogMaxSize is the size in megabytes after which the audit log is
+	// rotated. 0 disables rotation.
+	AuditLogMaxSize int
+	// AuditLogMaxBackups is the number of rotated audit logs kept.
+	AuditLogMaxBackups int
+	// AuditWebhookURL is the URL batches of audit events are POSTed to as a
+	// JSON array. Empty disables the webhook.
+	AuditWebhookURL string
+	// AuditWebhookBatchSize is the most audit events sent in one request.
+	AuditWebhookBatchSize int
+	// AuditWebhookBatchInterval is the longest an audit event is buffered
+	// before it is sent.
+	AuditWebhookBatchInterval time.Duration
+
+	// DenyListFile is a file of deny rules checked before any backend, reloaded
+	// when it changes. Empty disables it.
+	DenyListFile string
+	// DenyListConfigMap is the name of a ConfigMap in
+	// DenyListConfigMapNamespace whose deny key holds deny rules. Empty
+	// disables it.
+	DenyListConfigMap string
+	// DenyListConfigMapNamespace is the namespace of DenyListConfigMap.
+	DenyListConfigMapNamespace string
+	// DenyListCRD enables the deny rules of the RAMDenyRule objects.
+	DenyListCRD bool
+
 	//Dynamic File Path for DynamicFile BackendMode
 	DynamicFilePath string
+
+	// Clusters are served in addition to ClusterID, each with its own
+	// mappings. Tokens are mapped by the cluster they were generated for.
+	Clusters []ClusterConfig
+
+	// Audiences is the list of TokenReview audiences accepted for tokens
+	// generated for ClusterID. Reviews that request audiences are denied
+	// unless at least one of them is in this list. When empty, the server is
+	// audience agnostic and the apiserver's own audiences apply.
+	// +optional
+	Audiences []string
+
+	// TokenCacheSize is the maximum number of verified tokens kept in memory
+	// so that a token reused within its lifetime does not cost another STS
+	// call. A cached token keeps authenticating until it expires, up to 15
+	// minutes after its access key is revoked. 0 disables the cache.
+	TokenCacheSize int
+
+	// TokenCacheNegativeTTL is how long tokens definitively rejected by STS
+	// are remembered.
+	TokenCacheNegativeTTL time.Duration
+
+	// TokenClockSkew is how far the signing time of a v1 token may be off the
+	// server's clock before the token is rejected without calling STS.
+	TokenClockSkew time.Duration
+
+	// NonceStoreSize is the maximum number of signature nonces of verified
+	// tokens remembered to reject replayed tokens. Once reached, the nonces
+	// expiring first are forgotten. Replay protection is disabled when it is
+	// 0.
+	NonceStoreSize int
+	// NonceMaxReuses is how many times the client that first presented a
+	// token may present it again. Other clients may never present it again.
+	NonceMaxReuses int
+	// NonceReuseWindow is how long after its first use a token may be
+	// reused. Reuses are allowed until the token expires when it is 0.
+	NonceReuseWindow time.Duration
+
+	// ClientAddressRateLimitQPS and ClientAddressRateLimitBurst limit the
+	// TokenReviews accepted from the same client address, before their tokens
+	// are verified. Disabled when the QPS is 0.
+	ClientAddressRateLimitQPS   float64
+	ClientAddressRateLimitBurst int
+	// AccessKeyRateLimitQPS and AccessKeyRateLimitBurst limit the TokenReviews
+	// accepted for tokens verified to be signed with the same AccessKeyID,
+	// cached or not. Disabled when the QPS is 0.
+	AccessKeyRateLimitQPS   float64
+	AccessKeyRateLimitBurst int
+	// MaxConcurrentSTSCalls caps the number of STS calls in flight. 0 means unlimited.
+	MaxConcurrentSTSCalls int
+
+	// STSEndpoints are the STS endpoints, as host[:port], tried in order to
+	// verify tokens. Defaults to the VPC and public endpoints of Region, then
+	// the global endpoint.
+	STSEndpoints []string
+	// STSMaxRetries is how many times an STS call failing to connect is
+	// retried on the next endpoint.
+	STSMaxRetries int
+	// STSRetryBackoff is the base of the jittered exponential backoff
+	// between the attempts of an STS call.
+	STSRetryBackoff time.Duration
+	// STSBreakerFailures consecutive failures of an endpoint open its
+	// circuit breaker for STSBreakerCooldown. Disabled when it is 0.
+	STSBreakerFailures int
+	STSBreakerCooldown time.Duration
+
+	// ShutdownDelay is how long the server keeps serving after a termination
+	// signal with failing readiness checks, so that it is removed from the
+	// Service endpoints before it stops accepting connections.
+	ShutdownDelay time.Duration
+	// ShutdownGracePeriod is how long in-flight requests are given to finish
+	// after a termination signal before their connections are closed.
+	ShutdownGracePeriod time.Duration
+
+	// HealthAddress is the IP address the plain HTTP health and metrics
+	// server binds to. Empty binds all interfaces.
+	HealthAddress string
+	// HealthPort is the port of the plain HTTP server serving /metrics,
+	// /healthz, /readyz and /livez. The server is disabled when it is 0.
+	HealthPort int
+	// EnablePprof serves /debug/pprof on the health and metrics server.
+	EnablePprof bool
+
+	// ECSEndpoint is the ECS API endpoint used to resolve the ECS template
+	// variables, by default the VPC endpoint of Region.
+	ECSEndpoint string
+	// ECSInstanceCacheTTL is how long resolved ECS instances are cached.
+	// 0 disables the cache.
+	ECSInstanceCacheTTL time.Duration
+}
+
+// ClusterConfig is a cluster served by a multi-cluster server. Its mappings
+// and settings are not inherited from the server configuration.
+type ClusterConfig struct {
+	ClusterID string `mapstructure:"clusterID"`
+
+	// Audiences are the TokenReview audiences accepted for the cluster.
+	Audiences []string `mapstructure:"audiences"`
+
+	// BackendMode is an ordered list of backends to get mappings from.
+	// Defaults to MountedFile.
+	BackendMode []string `mapstructure:"backendMode"`
+
+	RoleMappings                   []RoleMapping    `mapstructure:"mapRoles"`
+	UserMappings                   []UserMapping    `mapstructure:"mapUsers"`
+	AutoMappedAlibabaCloudAccounts []AccountMapping `mapstructure:"mapAccounts"`
+	DynamicFilePath                string           `mapstructure:"dynamicFilePath"`
+
+	// Kubeconfig connects the ACKConfigMap and CRD backends to the cluster.
+	// It is required when either is a backend mode of the cluster.
+	Kubeconfig string `mapstructure:"kubeconfig"`
+
+	ScrubbedAliyunAccounts []string `mapstructure:"scrubbedAccounts"`
+
+	// GenerateKubeconfigPath is where the webhook kubeconfig of the cluster
+	// is written. Defaults to the server's path with the cluster ID appended.
+	GenerateKubeconfigPath string `mapstructure:"generateKubeconfig"`
 }
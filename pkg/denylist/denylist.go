@@ -0,0 +1,254 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package denylist denies verified identities before they are mapped, so
+// that a leaked access key or role session can be revoked in one place while
+// its presigned tokens are still valid.
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+)
+
+// defaultConfigMapNamespace is the namespace of the deny list ConfigMap if none
+// is configured.
+const defaultConfigMapNamespace = "kube-system"
+
+// Rule denies the identities matching all of its set fields.
+type Rule struct {
+	AccessKeyID string `json:"accessKeyID,omitempty"`
+	// ARN is a pattern matched against both the ARN and the canonical ARN,
+	// ignoring case. "*" matches any characters and "?" any one character.
+	ARN       string `json:"arn,omitempty"`
+	UserID    string `json:"userID,omitempty"`
+	AccountID string `json:"accountID,omitempty"`
+	// SessionName is a pattern like ARN, but case sensitive.
+	SessionName string `json:"sessionName,omitempty"`
+	// Reason is logged and audited when the rule denies an identity.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Rules is the content of a deny list file or of the deny key of a deny
+// list ConfigMap. The spec of a RAMDenyRule object is a single Rule.
+type Rules struct {
+	Deny []Rule `json:"deny"`
+}
+
+// Validate returns an error if the rule matches every identity or has an
+// invalid pattern.
+func (r Rule) Validate() error {
+	if r.AccessKeyID == "" && r.ARN == "" && r.UserID == "" && r.AccountID == "" && r.SessionName == "" {
+		return fmt.Errorf("deny rule %q matches every identity, one of accessKeyID, arn, userID, accountID and sessionName must be set", r.Reason)
+	}
+	return nil
+}
+
+func (r Rule) String() string {
+	var fields []string
+	for _, field := range []struct{ name, value string }{
+		{"accessKeyID", r.AccessKeyID},
+		{"arn", r.ARN},
+		{"userID", r.UserID},
+		{"accountID", r.AccountID},
+		{"sessionName", r.SessionName},
+	} {
+		if field.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", field.name, field.value))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// ParseRules parses deny rules in YAML or JSON. Invalid rules are returned in
+// the error and left out.
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules Rules
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+	rulesJSON, err := utilyaml.ToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(rulesJSON, &rules); err != nil {
+		return nil, err
+	}
+	var valid []Rule
+	var errs []string
+	for _, rule := range rules.Deny {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	if len(errs) > 0 {
+		return valid, fmt.Errorf("invalid deny rules: %s", strings.Join(errs, "; "))
+	}
+	return valid, nil
+}
+
+type compiledRule struct {
+	Rule
+	arn         *regexp.Regexp
+	sessionName *regexp.Regexp
+}
+
+func compile(rule Rule) compiledRule {
+	c := compiledRule{Rule: rule}
+	if rule.ARN != "" {
+		c.arn = globRegexp(rule.ARN, true)
+	}
+	if rule.SessionName != "" {
+		c.sessionName = globRegexp(rule.SessionName, false)
+	}
+	return c
+}
+
+func globRegexp(pattern string, ignoreCase bool) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile("^" + expr + "$")
+}
+
+func (r compiledRule) matches(identity *token.Identity) bool {
+	if r.AccessKeyID != "" && r.AccessKeyID != identity.AccessKeyID {
+		return false
+	}
+	if r.UserID != "" && r.UserID != identity.UserID {
+		return false
+	}
+	if r.AccountID != "" && r.AccountID != identity.AccountID {
+		return false
+	}
+	if r.arn != nil && !r.arn.MatchString(identity.ARN) && !r.arn.MatchString(identity.CanonicalARN) {
+		return false
+	}
+	if r.sessionName != nil && !r.sessionName.MatchString(identity.SessionName) {
+		return false
+	}
+	return true
+}
+
+// List holds the rules of each of its sources. The rules of a source are
+// replaced as a whole when it is reloaded.
+type List struct {
+	mutex   sync.RWMutex
+	rules   map[string][]compiledRule
+	sources []source
+}
+
+type source interface {
+	name() string
+	start(list *List, stopCh <-chan struct{})
+}
+
+// Options configures the sources of a List.
+type Options struct {
+	// File is the path of a file of Rules, reloaded when it changes.
+	File string
+	// ConfigMap is the name of a ConfigMap in ConfigMapNamespace whose deny
+	// key holds the deny rules.
+	ConfigMap string
+	// ConfigMapNamespace is the namespace of ConfigMap, kube-system if empty.
+	ConfigMapNamespace string
+	// CRD enables the deny rules of the RAMDenyRule objects.
+	CRD        bool
+	Master     string
+	Kubeconfig string
+}
+
+// New returns a List of the configured sources, or nil if none is
+// configured.
+func New(options Options) (*List, error) {
+	list := &List{rules: map[string][]compiledRule{}}
+	if options.File != "" {
+		list.sources = append(list.sources, &fileSource{path: options.File})
+	}
+	if options.ConfigMap != "" {
+		namespace := options.ConfigMapNamespace
+		if namespace == "" {
+			namespace = defaultConfigMapNamespace
+		}
+		cm, err := newConfigMapSource(namespace, options.ConfigMap, options.Master, options.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		list.sources = append(list.sources, cm)
+	}
+	if options.CRD {
+		source, err := newCRDSource(options.Master, options.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		list.sources = append(list.sources, source)
+	}
+	if len(list.sources) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
+
+// Start loads and watches the sources until stopCh is closed.
+func (l *List) Start(stopCh <-chan struct{}) {
+	for _, s := range l.sources {
+		s.start(l, stopCh)
+	}
+}
+
+// Deny returns the first rule matching the identity, if any.
+func (l *List) Deny(identity *token.Identity) (Rule, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, s := range l.sources {
+		for _, rule := range l.rules[s.name()] {
+			if rule.matches(identity) {
+				return rule.Rule, true
+			}
+		}
+	}
+	return Rule{}, false
+}
+
+// loadFailed counts a failure to load the rules of source.
+func loadFailed(source string) {
+	if metrics.Initialized() {
+		metrics.Get().DenyListLoadFailures.WithLabelValues(source).Inc()
+	}
+}
+
+func (l *List) set(source string, rules []Rule) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled = append(compiled, compile(rule))
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rules[source] = compiled
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package denylist
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/apis/ramauthenticator/v1alpha1"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/clientset/versioned/fake"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+)
+
+var identity = &token.Identity{
+	ARN:          "acs:ram::123456789012:assumed-role/Developers/alice",
+	CanonicalARN: "acs:ram::123456789012:role/developers",
+	AccountID:    "123456789012",
+	UserID:       "300800000000000000",
+	SessionName:  "alice",
+	AccessKeyID:  "STS.leaked",
+}
+
+func TestDeny(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		denied bool
+	}{
+		{Rule{AccessKeyID: "STS.leaked"}, true},
+		{Rule{AccessKeyID: "STS.other"}, false},
+		{Rule{ARN: "acs:ram::123456789012:role/developers"}, true},
+		{Rule{ARN: "ACS:RAM::123456789012:ASSUMED-ROLE/developers/*"}, true},
+		{Rule{ARN: "acs:ram::123456789012:role/dev?"}, false},
+		{Rule{ARN: "acs:ram::*:role/dev*"}, true},
+		{Rule{UserID: "300800000000000000"}, true},
+		{Rule{AccountID: "210987654321"}, false},
+		{Rule{AccountID: "123456789012", SessionName: "ali*"}, true},
+		{Rule{AccountID: "123456789012", SessionName: "Alice"}, false},
+		{Rule{ARN: "acs:ram::123456789012:role/developers", SessionName: "bob"}, false},
+		{Rule{ARN: "acs:ram::123456789012:role/developers.*"}, false},
+	}
+	for _, test := range tests {
+		list := &List{rules: map[string][]compiledRule{}, sources: []source{&fileSource{path: "test"}}}
+		list.set("file:test", []Rule{test.rule})
+		if _, denied := list.Deny(identity); denied != test.denied {
+			t.Errorf("expected rule %s to deny %v but was %v", test.rule, test.denied, denied)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+deny:
+- accessKeyID: STS.leaked
+  reason: leaked
+- reason: matches everything
+`))
+	if err == nil {
+		t.Errorf("expected an error for a rule without fields")
+	}
+	if len(rules) != 1 || rules[0].AccessKeyID != "STS.leaked" || rules[0].Reason != "leaked" {
+		t.Errorf("expected the valid rule to be parsed but got %+v", rules)
+	}
+
+	if rules, err := ParseRules(nil); err != nil || len(rules) != 0 {
+		t.Errorf("expected an empty list to have no rules, got %v, %v", rules, err)
+	}
+	if _, err := ParseRules([]byte("deny: [")); err == nil {
+		t.Errorf("expected an error for invalid YAML")
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deny.yaml")
+
+	list, err := New(Options{File: path})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	source := list.sources[0].(*fileSource)
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+		source.load(list)
+	}
+
+	write("deny:\n- accessKeyID: STS.leaked\n")
+	if _, denied := list.Deny(identity); !denied {
+		t.Errorf("expected the identity to be denied")
+	}
+	// an invalid file keeps the previous rules
+	write("deny: [")
+	if _, denied := list.Deny(identity); !denied {
+		t.Errorf("expected the previous rules to be kept")
+	}
+	// a missing file keeps the previous rules too, only an empty one clears them
+	os.Remove(path)
+	source.load(list)
+	if _, denied := list.Deny(identity); !denied {
+		t.Errorf("expected the rules of a removed file to be kept")
+	}
+	write("")
+	if _, denied := list.Deny(identity); denied {
+		t.Errorf("expected the rules of an emptied file to be cleared")
+	}
+}
+
+func TestCRDSource(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&v1alpha1.RAMDenyRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "leaked"},
+			Spec:       v1alpha1.RAMDenyRuleSpec{AccessKeyID: "STS.leaked", Reason: "leaked"},
+		},
+		&v1alpha1.RAMDenyRule{
+			ObjectMeta: metav1.ObjectMeta{Name: "everything"},
+			Spec:       v1alpha1.RAMDenyRuleSpec{Reason: "matches everything"},
+		},
+	)
+	s := newCRDSourceForClient(client)
+	list := &List{rules: map[string][]compiledRule{}, sources: []source{s}}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	list.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, s.informer.HasSynced) {
+		t.Fatalf("expected the informer to sync")
+	}
+
+	wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		_, denied := list.Deny(identity)
+		return denied, nil
+	})
+	rule, denied := list.Deny(identity)
+	if !denied || rule.Reason != "leaked" {
+		t.Fatalf("expected the identity to be denied by the valid rule but got %+v, %v", rule, denied)
+	}
+	if len(list.rules["crd"]) != 1 {
+		t.Errorf("expected the invalid rule to be left out but got %+v", list.rules["crd"])
+	}
+
+	if err := client.RamauthenticatorV1alpha1().RAMDenyRules().Delete(context.TODO(), "leaked", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		_, denied := list.Deny(identity)
+		return !denied, nil
+	})
+	if _, denied := list.Deny(identity); denied {
+		t.Errorf("expected the rule of the deleted object to be cleared")
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package denylist
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd"
+	clientset "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/clientset/versioned"
+	informers "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/informers/externalversions"
+	listers "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/listers/ramauthenticator/v1alpha1"
+)
+
+// fileSource loads the rules of a file and reloads them when it is written or
+// replaced. The rules are kept when the file is removed or becomes invalid,
+// so that a missing volume never lifts the denials; empty the file to clear
+// them.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) name() string {
+	return "file:" + s.path
+}
+
+func (s *fileSource) load(list *List) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		logrus.WithError(err).Errorf("could not read deny list %s, keeping the previous rules", s.path)
+		loadFailed(s.name())
+		return
+	}
+	rules, err := ParseRules(data)
+	if err != nil && rules == nil {
+		logrus.WithError(err).Errorf("could not parse deny list %s, keeping the previous rules", s.path)
+		loadFailed(s.name())
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("deny list %s has invalid rules, loading the valid ones", s.path)
+		loadFailed(s.name())
+	}
+	list.set(s.name(), rules)
+	logrus.Infof("loaded %d deny rules from %s", len(rules), s.path)
+}
+
+func (s *fileSource) start(list *List, stopCh <-chan struct{}) {
+	s.load(list)
+	go wait.Until(func() {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			logrus.WithError(err).Error("could not watch the deny list")
+			return
+		}
+		defer watcher.Close()
+		if err := watcher.Add(s.path); err != nil {
+			// retry until the file is created again
+			if !os.IsNotExist(err) {
+				logrus.WithError(err).Errorf("could not watch the deny list %s", s.path)
+			}
+			return
+		}
+		// the file may have changed before the watch was added
+		s.load(list)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case event := <-watcher.Events:
+				switch {
+				case event.Op&(fsnotify.Write|fsnotify.Create) != 0:
+					s.load(list)
+				case event.Op&(fsnotify.Rename|fsnotify.Remove) != 0:
+					// editors and ConfigMap volumes replace the file, watch
+					// it again once it is back
+					s.load(list)
+					return
+				}
+			case err := <-watcher.Errors:
+				logrus.WithError(err).Errorf("error watching the deny list %s", s.path)
+			}
+		}
+	}, time.Second, stopCh)
+}
+
+// configMapSource loads the rules of the deny key of a ConfigMap. The rules
+// are cleared when the ConfigMap is deleted.
+type configMapSource struct {
+	configMapName string
+	configMap     v1.ConfigMapInterface
+}
+
+func newConfigMapSource(namespace, name, masterURL, kubeConfig string) (*configMapSource, error) {
+	clientconfig, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, err
+	}
+	return &configMapSource{
+		configMapName: name,
+		configMap:     clientset.CoreV1().ConfigMaps(namespace),
+	}, nil
+}
+
+func (s *configMapSource) name() string {
+	return "configmap:" + s.configMapName
+}
+
+func (s *configMapSource) load(list *List, cm *core_v1.ConfigMap) {
+	rules, err := ParseRules([]byte(cm.Data["deny"]))
+	if err != nil && rules == nil {
+		logrus.WithError(err).Errorf("could not parse deny list configmap %s, keeping the previous rules", s.configMapName)
+		loadFailed(s.name())
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("deny list configmap %s has invalid rules, loading the valid ones", s.configMapName)
+		loadFailed(s.name())
+	}
+	list.set(s.name(), rules)
+	logrus.Infof("loaded %d deny rules from configmap %s", len(rules), s.configMapName)
+}
+
+func (s *configMapSource) start(list *List, stopCh <-chan struct{}) {
+	go wait.Until(func() {
+		watcher, err := s.configMap.Watch(context.TODO(), metav1.ListOptions{
+			Watch:         true,
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", s.configMapName).String(),
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("could not watch deny list configmap %s", s.configMapName)
+			return
+		}
+		defer watcher.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case r, ok := <-watcher.ResultChan():
+				if !ok {
+					return
+				}
+				switch r.Type {
+				case watch.Error:
+					logrus.WithField("error", r.Object).Errorf("error watching deny list configmap %s", s.configMapName)
+				case watch.Deleted:
+					logrus.Infof("deny list configmap %s deleted, clearing its rules", s.configMapName)
+					list.set(s.name(), nil)
+				case watch.Added, watch.Modified:
+					if cm, ok := r.Object.(*core_v1.ConfigMap); ok {
+						s.load(list, cm)
+					}
+				}
+			}
+		}
+	}, 5*time.Second, stopCh)
+}
+
+// crdSource loads the rules of the cluster scoped RAMDenyRule objects, one
+// rule per object. The rules of deleted objects are cleared and invalid
+// objects are left out.
+type crdSource struct {
+	informerFactory informers.SharedInformerFactory
+	informer        cache.SharedIndexInformer
+	lister          listers.RAMDenyRuleLister
+}
+
+func newCRDSource(masterURL, kubeConfig string) (*crdSource, error) {
+	clientconfig, err := clientcmd.BuildConfigFromFlags(masterURL, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	apiExtClientSet, err := apiextcs.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := crd.SyncDenyRuleCRD(apiExtClientSet); err != nil {
+		return nil, fmt.Errorf("failed to sync the RAMDenyRule crd: %v", err)
+	}
+	client, err := clientset.NewForConfig(clientconfig)
+	if err != nil {
+		return nil, err
+	}
+	return newCRDSourceForClient(client), nil
+}
+
+func newCRDSourceForClient(client clientset.Interface) *crdSource {
+	informerFactory := informers.NewSharedInformerFactory(client, time.Second*36000)
+	informer := informerFactory.Ramauthenticator().V1alpha1().RAMDenyRules()
+	return &crdSource{
+		informerFactory: informerFactory,
+		informer:        informer.Informer(),
+		lister:          informer.Lister(),
+	}
+}
+
+func (s *crdSource) name() string {
+	return "crd"
+}
+
+func (s *crdSource) load(list *List) {
+	objects, err := s.lister.List(labels.Everything())
+	if err != nil {
+		logrus.WithError(err).Error("could not list deny rules, keeping the previous rules")
+		loadFailed(s.name())
+		return
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	rules := make([]Rule, 0, len(objects))
+	for _, object := range objects {
+		rule := Rule{
+			AccessKeyID: object.Spec.AccessKeyID,
+			ARN:         object.Spec.ARN,
+			UserID:      object.Spec.UserID,
+			AccountID:   object.Spec.AccountID,
+			SessionName: object.Spec.SessionName,
+			Reason:      object.Spec.Reason,
+		}
+		if err := rule.Validate(); err != nil {
+			logrus.WithError(err).Errorf("invalid RAMDenyRule %s, leaving it out", object.Name)
+			loadFailed(s.name())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	list.set(s.name(), rules)
+	logrus.Infof("loaded %d deny rules from RAMDenyRules", len(rules))
+}
+
+func (s *crdSource) start(list *List, stopCh <-chan struct{}) {
+	reload := func(interface{}) { s.load(list) }
+	s.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    reload,
+		UpdateFunc: func(_, _ interface{}) { s.load(list) },
+		DeleteFunc: reload,
+	})
+	s.informerFactory.Start(stopCh)
+}
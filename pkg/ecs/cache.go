@@ -0,0 +1,77 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ecs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+)
+
+// defaultCacheSize bounds the cache when Options.CacheSize is not set.
+const defaultCacheSize = 10000
+
+// cachingResolver caches resolved instances for a TTL. Errors are not cached,
+// so an instance that was just created is found on the next request.
+type cachingResolver struct {
+	Resolver
+	mutex   sync.Mutex
+	entries *utils.LRU
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	instance  *Instance
+	expiresAt time.Time
+}
+
+func newCachingResolver(resolver Resolver, size int, ttl time.Duration) *cachingResolver {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &cachingResolver{
+		Resolver: resolver,
+		entries:  utils.NewLRU(size),
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+func (c *cachingResolver) Resolve(instanceID string) (*Instance, error) {
+	c.mutex.Lock()
+	if value, ok := c.entries.Get(instanceID); ok {
+		entry := value.(*cacheEntry)
+		if c.now().Before(entry.expiresAt) {
+			c.mutex.Unlock()
+			return entry.instance, nil
+		}
+		c.entries.Remove(instanceID)
+	}
+	c.mutex.Unlock()
+
+	instance, err := c.Resolver.Resolve(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.entries.Add(instanceID, &cacheEntry{instance: instance, expiresAt: c.now().Add(c.ttl)})
+	c.mutex.Unlock()
+	return instance, nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ecs resolves the ECS instances behind instance role sessions, whose
+// session name is the instance ID.
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-tool/pkg/credentials/provider"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/client"
+	util "github.com/alibabacloud-go/tea-utils/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/credentials-go/credentials"
+)
+
+const (
+	describeInstancesVersion = "2014-05-26"
+	defaultEndpoint          = "ecs-vpc.%s.aliyuncs.com"
+	defaultProtocol          = "https"
+)
+
+// InstanceIDPattern matches ECS instance IDs.
+var InstanceIDPattern = regexp.MustCompile(`^i-[a-z0-9]{8,32}$`)
+
+// sessionNamePattern matches the session names of ECS instance roles, the
+// default hostname "iZ<id>Z" of the instance "i-<id>".
+var sessionNamePattern = regexp.MustCompile(`^iZ([a-z0-9]{8,32})Z$`)
+
+// InstanceID returns the ID of the instance a session name refers to, either
+// an instance ID or the "iZ<id>Z" form of ECS instance role sessions.
+func InstanceID(sessionName string) (string, bool) {
+	if InstanceIDPattern.MatchString(sessionName) {
+		return sessionName, true
+	}
+	if match := sessionNamePattern.FindStringSubmatch(sessionName); match != nil {
+		return "i-" + match[1], true
+	}
+	return "", false
+}
+
+// ErrNotFound is returned when no instance has the requested ID.
+var ErrNotFound = errors.New("ECS instance not found")
+
+// Instance is the identity of an ECS instance.
+type Instance struct {
+	ID string
+	// PrivateDNSName is the name ACK gives the instance's node,
+	// "<region>.<private IP>".
+	PrivateDNSName string
+	Hostname       string
+	PrivateIP      string
+}
+
+// Resolver looks up ECS instances by ID, given as an instance ID or an
+// instance role session name, see InstanceID.
+type Resolver interface {
+	Resolve(instanceID string) (*Instance, error)
+}
+
+// Options configures the resolver returned by NewResolver.
+type Options struct {
+	// Region is the region of the instances, required.
+	Region string
+	// Endpoint is the ECS API endpoint, by default the VPC endpoint of
+	// Region. It is called over HTTPS unless it starts with "http://".
+	Endpoint string
+	// CacheTTL is how long resolved instances are cached, 0 disables caching.
+	CacheTTL time.Duration
+	// CacheSize bounds the number of cached instances.
+	CacheSize int
+	// Credential signs the API requests, by default the default credentials
+	// chain.
+	Credential credentials.Credential
+}
+
+// NewResolver returns a Resolver calling the DescribeInstances API, wrapped
+// in a TTL cache when CacheTTL is set.
+func NewResolver(options Options) (Resolver, error) {
+	if options.Region == "" {
+		return nil, fmt.Errorf("a region is required to resolve ECS instances")
+	}
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf(defaultEndpoint, options.Region)
+	}
+	protocol := defaultProtocol
+	if strings.HasPrefix(endpoint, "http://") {
+		protocol = "http"
+	}
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+	cred := options.Credential
+	if cred == nil {
+		cred = provider.NewCredentialForV2SDK(provider.DefaultChainProvider(), provider.CredentialForV2SDKOptions{})
+	}
+	client, err := openapi.NewClient(&openapi.Config{
+		Endpoint:   tea.String(endpoint),
+		Protocol:   tea.String(protocol),
+		RegionId:   tea.String(options.Region),
+		Credential: cred,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create ECS client: %v", err)
+	}
+
+	var resolver Resolver = &apiResolver{client: client, region: options.Region}
+	if options.CacheTTL > 0 {
+		resolver = newCachingResolver(resolver, options.CacheSize, options.CacheTTL)
+	}
+	return resolver, nil
+}
+
+// apiResolver resolves instances with the DescribeInstances API.
+type apiResolver struct {
+	client *openapi.Client
+	region string
+}
+
+type describeInstancesResponse struct {
+	Instances struct {
+		Instance []struct {
+			InstanceID    string `json:"InstanceId"`
+			HostName      string `json:"HostName"`
+			VpcAttributes struct {
+				PrivateIPAddress struct {
+					IPAddress []string `json:"IpAddress"`
+				} `json:"PrivateIpAddress"`
+			} `json:"VpcAttributes"`
+		} `json:"Instance"`
+	} `json:"Instances"`
+}
+
+func (r *apiResolver) Resolve(sessionName string) (*Instance, error) {
+	instanceID, ok := InstanceID(sessionName)
+	if !ok {
+		return nil, fmt.Errorf("%q is not an ECS instance ID", sessionName)
+	}
+	instanceIDs, _ := json.Marshal([]string{instanceID})
+	request := &openapi.OpenApiRequest{
+		Query: map[string]*string{
+			"RegionId":    tea.String(r.region),
+			"InstanceIds": tea.String(string(instanceIDs)),
+		},
+	}
+	result, err := r.client.DoRPCRequest(tea.String("DescribeInstances"), tea.String(describeInstancesVersion),
+		tea.String(defaultProtocol), tea.String("POST"), tea.String("AK"), tea.String("json"), request, &util.RuntimeOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("DescribeInstances %s: %v", instanceID, err)
+	}
+
+	// the body is decoded into generic maps, decode it again into the
+	// fields we need
+	body, err := json.Marshal(result["body"])
+	if err != nil {
+		return nil, err
+	}
+	var response describeInstancesResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("could not decode DescribeInstances response: %v", err)
+	}
+	for _, instance := range response.Instances.Instance {
+		if instance.InstanceID != instanceID {
+			continue
+		}
+		resolved := &Instance{
+			ID:       instance.InstanceID,
+			Hostname: instance.HostName,
+		}
+		if ips := instance.VpcAttributes.PrivateIPAddress.IPAddress; len(ips) > 0 {
+			resolved.PrivateIP = ips[0]
+			resolved.PrivateDNSName = r.region + "." + ips[0]
+		}
+		return resolved, nil
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ecs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-tool/pkg/credentials/provider"
+)
+
+const instanceID = "i-bp1g6zv0ce8oghu7k3xy"
+
+// fakeECS serves DescribeInstances for a single instance and counts calls.
+func fakeECS(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		query := r.URL.Query()
+		if query.Get("Action") != "DescribeInstances" || query.Get("RegionId") != "cn-hangzhou" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(query.Get("InstanceIds"), instanceID) {
+			fmt.Fprint(w, `{"RequestId":"1","Instances":{"Instance":[]}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"RequestId":"1","Instances":{"Instance":[{"InstanceId":%q,"HostName":"iZbp1g6zv0ce8oghu7k3xyZ","VpcAttributes":{"PrivateIpAddress":{"IpAddress":["192.168.0.10"]}}}]}}`, instanceID)
+	}))
+}
+
+func newTestResolver(t *testing.T, server *httptest.Server, ttl time.Duration) Resolver {
+	resolver, err := NewResolver(Options{
+		Region:     "cn-hangzhou",
+		Endpoint:   server.URL,
+		CacheTTL:   ttl,
+		Credential: provider.NewCredentialForV2SDK(provider.NewAccessKeyProvider("ak", "secret"), provider.CredentialForV2SDKOptions{}),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	return resolver
+}
+
+func TestResolve(t *testing.T) {
+	var calls int32
+	server := fakeECS(t, &calls)
+	defer server.Close()
+	resolver := newTestResolver(t, server, 0)
+
+	instance, err := resolver.Resolve(instanceID)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	expected := Instance{
+		ID:             instanceID,
+		PrivateDNSName: "cn-hangzhou.192.168.0.10",
+		Hostname:       "iZbp1g6zv0ce8oghu7k3xyZ",
+		PrivateIP:      "192.168.0.10",
+	}
+	if *instance != expected {
+		t.Errorf("expected %+v but was %+v", expected, *instance)
+	}
+
+	instance, err = resolver.Resolve("iZbp1g6zv0ce8oghu7k3xyZ")
+	if err != nil {
+		t.Fatalf("expected the session name of the instance role to resolve but got %q", err)
+	}
+	if *instance != expected {
+		t.Errorf("expected %+v but was %+v", expected, *instance)
+	}
+
+	if _, err := resolver.Resolve("i-bp1aaaaaaaaaaaaaaaaa"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound but was %v", err)
+	}
+	if _, err := resolver.Resolve("alice"); err == nil {
+		t.Errorf("expected an error for a session name that is not an instance ID")
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 DescribeInstances calls but was %d", calls)
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	tests := map[string]string{
+		"i-bp1g6zv0ce8oghu7k3xy":  "i-bp1g6zv0ce8oghu7k3xy",
+		"iZj6c792gcdoonnp1rd5y8Z": "i-j6c792gcdoonnp1rd5y8",
+		"iZj6c792gcdoonnp1rd5y8":  "",
+		"iz-alice":                "",
+		"alice":                   "",
+	}
+	for sessionName, expected := range tests {
+		instanceID, ok := InstanceID(sessionName)
+		if instanceID != expected || ok != (expected != "") {
+			t.Errorf("%s: expected instance ID %q but was %q, %v", sessionName, expected, instanceID, ok)
+		}
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	var calls int32
+	server := fakeECS(t, &calls)
+	defer server.Close()
+	resolver := newTestResolver(t, server, time.Minute)
+	cache := resolver.(*cachingResolver)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.Resolve(instanceID); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected cached instances to be reused, got %d calls", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := resolver.Resolve(instanceID); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected an expired instance to be resolved again, got %d calls", calls)
+	}
+}
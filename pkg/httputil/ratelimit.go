@@ -0,0 +1,69 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+	"golang.org/x/time/rate"
+)
+
+// KeyedRateLimiter keeps a separate token bucket for every key (e.g. a client
+// address or an AccessKeyID). At most size keys are tracked, the least
+// recently seen ones are forgotten first.
+type KeyedRateLimiter struct {
+	mutex    sync.Mutex
+	qps      rate.Limit
+	burst    int
+	limiters *utils.LRU
+}
+
+// NewKeyedRateLimiter returns a KeyedRateLimiter allowing qps requests per
+// second with the given burst for every key.
+func NewKeyedRateLimiter(qps float64, burst int, size int) (*KeyedRateLimiter, error) {
+	if qps <= 0 {
+		return nil, fmt.Errorf("qps expected >0, got %v", qps)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("burst expected >0, got %d", burst)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("size expected >0, got %d", size)
+	}
+	return &KeyedRateLimiter{
+		qps:      rate.Limit(qps),
+		burst:    burst,
+		limiters: utils.NewLRU(size),
+	}, nil
+}
+
+// Allow reports whether a request for key may happen now.
+func (l *KeyedRateLimiter) Allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var limiter *rate.Limiter
+	if v, ok := l.limiters.Get(key); ok {
+		limiter = v.(*rate.Limiter)
+	} else {
+		limiter = rate.NewLimiter(l.qps, l.burst)
+		l.limiters.Add(key, limiter)
+	}
+	return limiter.Allow()
+}
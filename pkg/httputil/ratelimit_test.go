@@ -0,0 +1,48 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httputil
+
+import "testing"
+
+func TestKeyedRateLimiter(t *testing.T) {
+	if _, err := NewKeyedRateLimiter(1, 0, 10); err == nil {
+		t.Fatal("expected error for burst 0")
+	}
+
+	l, err := NewKeyedRateLimiter(0.001, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("request %d for a should be allowed within burst", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatal("request for a should be throttled after burst")
+	}
+	if !l.Allow("b") {
+		t.Fatal("keys should be limited independently")
+	}
+
+	// tracking a third key forgets the least recently seen one
+	l.Allow("c")
+	if !l.Allow("a") {
+		t.Fatal("forgotten key should start with a full bucket")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,16 +21,20 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
 )
 
 type MapStore struct {
-	mutex sync.RWMutex
-	users map[string]config.UserMapping
-	roles map[string]config.RoleMapping
-	// Used as set.
-	alibabaCloudAccounts map[string]interface{}
+	mutex                sync.RWMutex
+	users                map[string]config.UserMapping
+	roles                map[string]config.RoleMapping
+	alibabaCloudAccounts map[string]config.AccountMapping
 	configMap            v1.ConfigMapInterface
+	// watching is true while a watch on the configmap is established
+	watching bool
+	// watchErr is the last error seen while watching the configmap
+	watchErr error
 }
 
 func New(masterURL, kubeConfig string) (*MapStore, error) {
@@ -63,19 +68,22 @@ func (ms *MapStore) startLoadConfigMap(stopCh <-chan struct{}) {
 				if err != nil {
 					logrus.Errorf("Unable to re-establish watch: %v, sleeping for 5 seconds.", err)
 					metrics.Get().ConfigMapWatchFailures.Inc()
+					ms.setWatchState(false, err)
 					time.Sleep(5 * time.Second)
 					continue
 				}
+				ms.setWatchState(true, nil)
 
 				for r := range watcher.ResultChan() {
 					switch r.Type {
 					case watch.Error:
 						logrus.WithFields(logrus.Fields{"error": r}).Error("recieved a watch error")
+						ms.setWatchState(true, fmt.Errorf("watch error: %v", r.Object))
 					case watch.Deleted:
 						logrus.Info("Resetting configmap on delete")
 						userMappings := make([]config.UserMapping, 0)
 						roleMappings := make([]config.RoleMapping, 0)
-						alibabaCloudAccounts := make([]string, 0)
+						alibabaCloudAccounts := make([]config.AccountMapping, 0)
 						ms.saveMap(userMappings, roleMappings, alibabaCloudAccounts)
 					case watch.Added, watch.Modified:
 						switch cm := r.Object.(type) {
@@ -97,6 +105,7 @@ func (ms *MapStore) startLoadConfigMap(stopCh <-chan struct{}) {
 					}
 				}
 				logrus.Error("Watch channel closed.")
+				ms.setWatchState(false, errors.New("watch channel closed"))
 			}
 		}
 	}()
@@ -110,7 +119,7 @@ func (err ErrParsingMap) Error() string {
 	return fmt.Sprintf("error parsing config map: %v", err.errors)
 }
 
-func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappings []config.RoleMapping, alibabaCloudAccounts []string, err error) {
+func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappings []config.RoleMapping, alibabaCloudAccounts []config.AccountMapping, err error) {
 	errs := make([]error, 0)
 	rawUserMappings := make([]config.UserMapping, 0)
 	userMappings = make([]config.UserMapping, 0)
@@ -158,11 +167,26 @@ func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappi
 		}
 	}
 
-	alibabaCloudAccounts = make([]string, 0)
+	rawAccountMappings := make([]config.AccountMapping, 0)
+	alibabaCloudAccounts = make([]config.AccountMapping, 0)
 	if accountsData, ok := m["mapAccounts"]; ok {
-		err := yaml.Unmarshal([]byte(accountsData), &alibabaCloudAccounts)
+		accountsJson, err := utilyaml.ToJSON([]byte(accountsData))
 		if err != nil {
 			errs = append(errs, err)
+		} else {
+			err = json.Unmarshal(accountsJson, &rawAccountMappings)
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+			for _, accountMapping := range rawAccountMappings {
+				err = accountMapping.Validate()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					alibabaCloudAccounts = append(alibabaCloudAccounts, accountMapping)
+				}
+			}
 		}
 	}
 
@@ -173,7 +197,7 @@ func ParseMap(m map[string]string) (userMappings []config.UserMapping, roleMappi
 	return userMappings, roleMappings, alibabaCloudAccounts, err
 }
 
-func EncodeMap(userMappings []config.UserMapping, roleMappings []config.RoleMapping, alibabaCloudAccounts []string) (m map[string]string, err error) {
+func EncodeMap(userMappings []config.UserMapping, roleMappings []config.RoleMapping, alibabaCloudAccounts []config.AccountMapping) (m map[string]string, err error) {
 	m = make(map[string]string)
 
 	if len(userMappings) > 0 {
@@ -206,13 +230,13 @@ func EncodeMap(userMappings []config.UserMapping, roleMappings []config.RoleMapp
 func (ms *MapStore) saveMap(
 	userMappings []config.UserMapping,
 	roleMappings []config.RoleMapping,
-	alibabaCloudAccounts []string) {
+	alibabaCloudAccounts []config.AccountMapping) {
 
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 	ms.users = make(map[string]config.UserMapping)
 	ms.roles = make(map[string]config.RoleMapping)
-	ms.alibabaCloudAccounts = make(map[string]interface{})
+	ms.alibabaCloudAccounts = make(map[string]config.AccountMapping)
 
 	for _, user := range userMappings {
 		ms.users[user.Key()] = user
@@ -221,8 +245,28 @@ func (ms *MapStore) saveMap(
 		ms.roles[role.Key()] = role
 	}
 	for _, aliAccount := range alibabaCloudAccounts {
-		ms.alibabaCloudAccounts[aliAccount] = nil
+		ms.alibabaCloudAccounts[aliAccount.AccountID] = aliAccount
+	}
+}
+
+func (ms *MapStore) setWatchState(watching bool, err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.watching = watching
+	ms.watchErr = err
+}
+
+// Ready returns nil while the configmap is watched without errors.
+func (ms *MapStore) Ready() error {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	if ms.watchErr != nil {
+		return ms.watchErr
+	}
+	if !ms.watching {
+		return errors.New("configmap alibabacloud-auth is not watched yet")
 	}
+	return nil
 }
 
 // UserNotFound is the error returned when the user is not found in the config map.
@@ -253,9 +297,23 @@ func (ms *MapStore) RoleMapping(arn string) (config.RoleMapping, error) {
 	return config.RoleMapping{}, RoleNotFound
 }
 
-func (ms *MapStore) AlibabaCloudAccount(id string) bool {
+func (ms *MapStore) AlibabaCloudAccount(id string) (config.AccountMapping, bool) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	account, ok := ms.alibabaCloudAccounts[id]
+	return account, ok
+}
+
+// ListMappings returns the role and user mappings of the configmap.
+func (ms *MapStore) ListMappings() []mapper.ListedMapping {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
-	_, ok := ms.alibabaCloudAccounts[id]
-	return ok
+	var mappings []mapper.ListedMapping
+	for _, role := range ms.roles {
+		mappings = append(mappings, mapper.ListedMapping{ARN: strings.ToLower(role.RoleARN), Username: role.Username, Groups: role.Groups})
+	}
+	for _, user := range ms.users {
+		mappings = append(mappings, mapper.ListedMapping{ARN: strings.ToLower(user.UserARN), Username: user.Username, Groups: user.Groups})
+	}
+	return mappings
 }
@@ -38,6 +38,7 @@ func (m *ConfigMapMapper) Map(canonicalARN string) (*config.IdentityMapping, err
 	if err == nil {
 		return &config.IdentityMapping{
 			IdentityARN: canonicalARN,
+			Name:        rm.RoleARN,
 			Username:    rm.Username,
 			Groups:      rm.Groups,
 		}, nil
@@ -47,6 +48,7 @@ func (m *ConfigMapMapper) Map(canonicalARN string) (*config.IdentityMapping, err
 	if err == nil {
 		return &config.IdentityMapping{
 			IdentityARN: canonicalARN,
+			Name:        um.UserARN,
 			Username:    um.Username,
 			Groups:      um.Groups,
 		}, nil
@@ -55,6 +57,9 @@ func (m *ConfigMapMapper) Map(canonicalARN string) (*config.IdentityMapping, err
 	return nil, mapper.ErrNotMapped
 }
 
-func (m *ConfigMapMapper) IsAccountAllowed(accountID string) bool {
-	return m.AlibabaCloudAccount(accountID)
+func (m *ConfigMapMapper) MapAccount(accountID string) (*config.IdentityMapping, error) {
+	if accountMapping, ok := m.AlibabaCloudAccount(accountID); ok {
+		return accountMapping.IdentityMapping(), nil
+	}
+	return nil, mapper.ErrNotMapped
 }
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// ConflictDuplicate is an ARN mapped more than once to the same user.
+	ConflictDuplicate = "duplicate"
+	// ConflictMismatch is an ARN mapped more than once to different users,
+	// only one of which is used.
+	ConflictMismatch = "conflict"
+	// ConflictShadowed is an exact mapping never used because a wildcard
+	// mapping of an earlier backend matches its ARN first.
+	ConflictShadowed = "shadowed"
+)
+
+var ConflictKinds = []string{ConflictDuplicate, ConflictMismatch, ConflictShadowed}
+
+// ListedMapping is a mapping as stored by a backend.
+type ListedMapping struct {
+	// ARN is the lower case canonical ARN the mapping is looked up by, or
+	// the pattern of a wildcard mapping.
+	ARN string
+	// Matches is set for wildcard mappings and reports whether the pattern
+	// matches a canonical ARN the way Map does.
+	Matches func(canonicalARN string) bool
+	// Object names the Kubernetes object the mapping is defined in, for
+	// backends that keep one mapping per object.
+	Object   string
+	Username string
+	Groups   []string
+}
+
+// MappingLister is implemented by mappers that can list their mappings, so
+// that mappings of the same ARN can be compared across backends.
+type MappingLister interface {
+	ListMappings() []ListedMapping
+}
+
+// ConflictReporter is implemented by mappers that can surface a conflict on
+// the object a mapping is defined in.
+type ConflictReporter interface {
+	ReportConflict(object string, message string)
+}
+
+// ConflictEntry is one of the mappings involved in a Conflict.
+type ConflictEntry struct {
+	Backend string
+	ListedMapping
+}
+
+// Conflict is a set of mappings of the same ARN.
+type Conflict struct {
+	Kind    string
+	ARN     string
+	Entries []ConflictEntry
+}
+
+func (c Conflict) String() string {
+	var entries []string
+	for _, e := range c.Entries {
+		source := e.Backend
+		if e.Object != "" {
+			source += "/" + e.Object
+		}
+		if e.Matches != nil {
+			source += " pattern " + e.ARN
+		}
+		entries = append(entries, fmt.Sprintf("%s (username %q, groups %q)", source, e.Username, e.Groups))
+	}
+	return fmt.Sprintf("%s mappings of %s: %s", c.Kind, c.ARN, strings.Join(entries, ", "))
+}
+
+// DetectConflicts compares the mappings of the ready mappers that implement
+// MappingLister, in chain order. Mappings of the same ARN are a duplicate if
+// they are equal and a conflict otherwise; with ChainPolicyMerge, mappings
+// of different backends only conflict if their usernames differ, since
+// their groups are merged. With ChainPolicyFirst, wildcard mappings that
+// match an exact mapping of a later backend shadow it.
+func DetectConflicts(mappers []Mapper, policy string) []Conflict {
+	type entry struct {
+		ConflictEntry
+		index int
+	}
+	var exact []entry
+	var wildcards []entry
+	for i, m := range mappers {
+		lister, ok := m.(MappingLister)
+		if !ok || m.Ready() != nil {
+			continue
+		}
+		for _, listed := range lister.ListMappings() {
+			e := entry{ConflictEntry{Backend: m.Name(), ListedMapping: listed}, i}
+			if listed.Matches != nil {
+				wildcards = append(wildcards, e)
+			} else {
+				exact = append(exact, e)
+			}
+		}
+	}
+
+	byARN := map[string][]entry{}
+	var arns []string
+	for _, e := range exact {
+		if _, ok := byARN[e.ARN]; !ok {
+			arns = append(arns, e.ARN)
+		}
+		byARN[e.ARN] = append(byARN[e.ARN], e)
+	}
+	sort.Strings(arns)
+
+	var conflicts []Conflict
+	for _, arn := range arns {
+		entries := byARN[arn]
+		if len(entries) < 2 {
+			continue
+		}
+		kind := ConflictDuplicate
+		merged := false
+		first := entries[0]
+		for _, e := range entries[1:] {
+			switch {
+			case e.Username != first.Username:
+				kind = ConflictMismatch
+			case sameGroups(e.Groups, first.Groups):
+			case policy == ChainPolicyMerge && e.index != first.index:
+				merged = true
+			default:
+				kind = ConflictMismatch
+			}
+		}
+		// groups of different backends are meant to be merged
+		if kind == ConflictDuplicate && merged {
+			continue
+		}
+		c := Conflict{Kind: kind, ARN: arn}
+		for _, e := range entries {
+			c.Entries = append(c.Entries, e.ConflictEntry)
+		}
+		conflicts = append(conflicts, c)
+	}
+
+	if policy == ChainPolicyMerge {
+		return conflicts
+	}
+	for _, w := range wildcards {
+		for _, arn := range arns {
+			// exact mappings of the same or an earlier backend win over
+			// the wildcard
+			var shadowed []ConflictEntry
+			wins := true
+			for _, e := range byARN[arn] {
+				if e.index <= w.index {
+					wins = false
+				} else if e.Username != w.Username || !sameGroups(e.Groups, w.Groups) {
+					shadowed = append(shadowed, e.ConflictEntry)
+				}
+			}
+			if !wins || len(shadowed) == 0 || !w.Matches(arn) {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{
+				Kind:    ConflictShadowed,
+				ARN:     arn,
+				Entries: append([]ConflictEntry{w.ConflictEntry}, shadowed...),
+			})
+		}
+	}
+	return conflicts
+}
+
+func sameGroups(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
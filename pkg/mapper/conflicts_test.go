@@ -0,0 +1,152 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mapper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+)
+
+type listingMapper struct {
+	name     string
+	mappings []ListedMapping
+}
+
+func (m *listingMapper) Name() string                                { return m.name }
+func (m *listingMapper) Start(<-chan struct{}) error                 { return nil }
+func (m *listingMapper) Ready() error                                { return nil }
+func (m *listingMapper) Map(string) (*config.IdentityMapping, error) { return nil, ErrNotMapped }
+func (m *listingMapper) MapAccount(string) (*config.IdentityMapping, error) {
+	return nil, ErrNotMapped
+}
+func (m *listingMapper) ListMappings() []ListedMapping { return m.mappings }
+
+const role = "acs:ram::123456789012:role/developers"
+
+func wildcard(prefix string) ListedMapping {
+	return ListedMapping{
+		ARN:      prefix + "*",
+		Object:   "wildcard",
+		Username: "wild",
+		Matches:  func(arn string) bool { return strings.HasPrefix(arn, prefix) },
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		mappers  []Mapper
+		expected []string
+	}{
+		{
+			name:   "duplicate",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeMountedFile, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"a", "b"}}}},
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"b", "a"}}}},
+			},
+			expected: []string{ConflictDuplicate},
+		},
+		{
+			name:   "different groups",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeMountedFile, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"a"}}}},
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"b"}}}},
+			},
+			expected: []string{ConflictMismatch},
+		},
+		{
+			name:   "merged groups",
+			policy: ChainPolicyMerge,
+			mappers: []Mapper{
+				&listingMapper{name: ModeMountedFile, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"a"}}}},
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{{ARN: role, Username: "dev", Groups: []string{"b"}}}},
+			},
+		},
+		{
+			name:   "merged usernames",
+			policy: ChainPolicyMerge,
+			mappers: []Mapper{
+				&listingMapper{name: ModeMountedFile, mappings: []ListedMapping{{ARN: role, Username: "dev"}}},
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{{ARN: role, Username: "ops"}}},
+			},
+			expected: []string{ConflictMismatch},
+		},
+		{
+			name:   "same backend",
+			policy: ChainPolicyMerge,
+			mappers: []Mapper{
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{
+					{ARN: role, Object: "a", Username: "dev", Groups: []string{"a"}},
+					{ARN: role, Object: "b", Username: "dev", Groups: []string{"b"}},
+				}},
+			},
+			expected: []string{ConflictMismatch},
+		},
+		{
+			name:   "shadowed",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{wildcard("acs:ram::123456789012:role/")}},
+				&listingMapper{name: ModeACKConfigMap, mappings: []ListedMapping{{ARN: role, Username: "dev"}}},
+			},
+			expected: []string{ConflictShadowed},
+		},
+		{
+			name:   "exact mapping of the same backend wins",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{
+					wildcard("acs:ram::123456789012:role/"),
+					{ARN: role, Object: "exact", Username: "dev"},
+				}},
+				&listingMapper{name: ModeACKConfigMap, mappings: []ListedMapping{{ARN: role, Username: "dev"}}},
+			},
+			expected: []string{ConflictDuplicate},
+		},
+		{
+			name:   "wildcard of a later backend",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeACKConfigMap, mappings: []ListedMapping{{ARN: role, Username: "dev"}}},
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{wildcard("acs:ram::123456789012:role/")}},
+			},
+		},
+		{
+			name:   "wildcard that does not match",
+			policy: ChainPolicyFirst,
+			mappers: []Mapper{
+				&listingMapper{name: ModeCRD, mappings: []ListedMapping{wildcard("acs:ram::999999999999:role/")}},
+				&listingMapper{name: ModeACKConfigMap, mappings: []ListedMapping{{ARN: role, Username: "dev"}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		conflicts := DetectConflicts(test.mappers, test.policy)
+		var kinds []string
+		for _, conflict := range conflicts {
+			kinds = append(kinds, conflict.Kind)
+		}
+		if strings.Join(kinds, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("%s: expected %v but got %v", test.name, test.expected, conflicts)
+		}
+	}
+}
@@ -45,6 +45,8 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&RAMDenyRule{},
+		&RAMDenyRuleList{},
 		&RAMIdentityMapping{},
 		&RAMIdentityMappingList{},
 	)
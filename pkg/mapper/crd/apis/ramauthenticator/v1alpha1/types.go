@@ -56,3 +56,38 @@ type RAMIdentityMappingList struct {
 
 	Items []RAMIdentityMapping `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RAMDenyRule is a specification for a RAMDenyRule resource
+type RAMDenyRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RAMDenyRuleSpec `json:"spec"`
+}
+
+// RAMDenyRuleSpec is the spec for a RAMDenyRule resource. It denies the
+// identities matching all of its set fields.
+type RAMDenyRuleSpec struct {
+	AccessKeyID string `json:"accessKeyID,omitempty"`
+	ARN         string `json:"arn,omitempty"`
+	UserID      string `json:"userID,omitempty"`
+	AccountID   string `json:"accountID,omitempty"`
+	SessionName string `json:"sessionName,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RAMDenyRuleList is a list of RAMDenyRule resources
+type RAMDenyRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []RAMDenyRule `json:"items"`
+}
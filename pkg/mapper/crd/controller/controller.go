@@ -37,6 +37,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/arn"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
 	ramauthenticatorv1alpha1 "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/apis/ramauthenticator/v1alpha1"
 	clientset "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/clientset/versioned"
 	ramscheme "github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd/generated/clientset/versioned/scheme"
@@ -53,6 +54,14 @@ const (
 
 	// IdentitySynced is the `message` when an Identity is synced
 	IdentitySynced = "Identity synced successfully"
+
+	// ErrInvalidTemplate is used as part of the Event 'reason' when the username
+	// or a group of an Identity is not a valid template
+	ErrInvalidTemplate = "InvalidTemplate"
+
+	// MappingConflict is used as part of the Event 'reason' when an Identity
+	// conflicts with a mapping of the same ARN
+	MappingConflict = "MappingConflict"
 )
 
 // Controller implements the logic for getting and mutating RAMIdentityMappings
@@ -248,6 +257,13 @@ func (c *Controller) syncHandler(key string) (err error) {
 		return err
 	}
 
+	// mappings with invalid templates are not synced, lookups fail closed
+	if err := config.ValidateTemplates(ramIdentityMapping.Spec.Username, ramIdentityMapping.Spec.Groups); err != nil {
+		logrus.Warnf("ram identity mapping %s has an invalid template: %v", name, err)
+		c.recorder.Event(ramIdentityMapping, corev1.EventTypeWarning, ErrInvalidTemplate, err.Error())
+		return nil
+	}
+
 	// Process items
 	if ramIdentityMapping.Spec.ARN != "" {
 		ramIdentityMappingCopy := ramIdentityMapping.DeepCopy()
@@ -281,6 +297,16 @@ func (c *Controller) syncHandler(key string) (err error) {
 	return nil
 }
 
+// RecordWarning emits a Warning Event on the RAMIdentityMapping with the given name.
+func (c *Controller) RecordWarning(name, reason, message string) {
+	ramIdentityMapping, err := c.ramMappingLister.Get(name)
+	if err != nil {
+		logrus.WithError(err).Warnf("could not record %s event on ram identity mapping %s", reason, name)
+		return
+	}
+	c.recorder.Event(ramIdentityMapping, corev1.EventTypeWarning, reason, message)
+}
+
 // enqueueRAMIdentityMapping will pull in a new RAMIdentityMapping and update it
 func (c *Controller) enqueueRAMIdentityMapping(obj interface{}) {
 	var key string
@@ -27,6 +27,10 @@ type FakeRamauthenticatorV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeRamauthenticatorV1alpha1) RAMDenyRules() v1alpha1.RAMDenyRuleInterface {
+	return &FakeRAMDenyRules{c}
+}
+
 func (c *FakeRamauthenticatorV1alpha1) RAMIdentityMappings() v1alpha1.RAMIdentityMappingInterface {
 	return &FakeRAMIdentityMappings{c}
 }
@@ -25,6 +25,7 @@ import (
 
 type RamauthenticatorV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	RAMDenyRulesGetter
 	RAMIdentityMappingsGetter
 }
 
@@ -33,6 +34,10 @@ type RamauthenticatorV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *RamauthenticatorV1alpha1Client) RAMDenyRules() RAMDenyRuleInterface {
+	return newRAMDenyRules(c)
+}
+
 func (c *RamauthenticatorV1alpha1Client) RAMIdentityMappings() RAMIdentityMappingInterface {
 	return newRAMIdentityMappings(c)
 }
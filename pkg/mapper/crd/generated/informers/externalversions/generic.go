@@ -52,6 +52,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=ramauthenticator.k8s.alibabacloud
+	case v1alpha1.SchemeGroupVersion.WithResource("ramdenyrules"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Ramauthenticator().V1alpha1().RAMDenyRules().Informer()}, nil
 	case v1alpha1.SchemeGroupVersion.WithResource("ramidentitymappings"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Ramauthenticator().V1alpha1().RAMIdentityMappings().Informer()}, nil
 
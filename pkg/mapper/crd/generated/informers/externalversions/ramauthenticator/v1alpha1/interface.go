@@ -23,6 +23,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// RAMDenyRules returns a RAMDenyRuleInformer.
+	RAMDenyRules() RAMDenyRuleInformer
 	// RAMIdentityMappings returns a RAMIdentityMappingInformer.
 	RAMIdentityMappings() RAMIdentityMappingInformer
 }
@@ -38,6 +40,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// RAMDenyRules returns a RAMDenyRuleInformer.
+func (v *version) RAMDenyRules() RAMDenyRuleInformer {
+	return &rAMDenyRuleInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // RAMIdentityMappings returns a RAMIdentityMappingInformer.
 func (v *version) RAMIdentityMappings() RAMIdentityMappingInformer {
 	return &rAMIdentityMappingInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
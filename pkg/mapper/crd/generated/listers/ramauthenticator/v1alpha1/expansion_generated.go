@@ -17,6 +17,10 @@ limitations under the License.
 
 package v1alpha1
 
+// RAMDenyRuleListerExpansion allows custom methods to be added to
+// RAMDenyRuleLister.
+type RAMDenyRuleListerExpansion interface{}
+
 // RAMIdentityMappingListerExpansion allows custom methods to be added to
 // RAMIdentityMappingLister.
 type RAMIdentityMappingListerExpansion interface{}
@@ -1,6 +1,7 @@
 package crd
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -88,6 +89,20 @@ func (m *CRDMapper) Start(stopCh <-chan struct{}) error {
 	return nil
 }
 
+func (m *CRDMapper) Ready() error {
+	// mappers built from a bare indexer have nothing to sync
+	if m.ramMappingsSynced == nil {
+		return nil
+	}
+	if !m.ramMappingsSynced() {
+		return errors.New("RAMIdentityMapping informer has not synced")
+	}
+	if m.WildMappingCache.Load() == nil {
+		return errors.New("controller has not started")
+	}
+	return nil
+}
+
 func (m *CRDMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 	canonicalARN = strings.ToLower(canonicalARN)
 
@@ -134,6 +149,7 @@ func (m *CRDMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 		if ramidentity != nil {
 			return &config.IdentityMapping{
 				IdentityARN: canonicalARN,
+				Name:        ramidentity.Name,
 				Username:    ramidentity.Spec.Username,
 				Groups:      ramidentity.Spec.Groups,
 			}, nil
@@ -142,15 +158,16 @@ func (m *CRDMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 		//check if matching wild mapping definition in wild mapping cache
 		wildMappingCache := m.WildMappingCache.Load().(controller.WildMappingMap)
 		for _, ri := range wildMappingCache {
-			matched, err := regexp.MatchString(ri.Status.CanonicalARN, canonicalARN)
+			matched, err := matchesWildMapping(ri, canonicalARN)
 			if err != nil {
-				logrus.Errorf("check canonicalARN with pattern %s failed, error: %v", err)
+				logrus.Errorf("check canonicalARN with pattern %s failed, error: %v", ri.Spec.ARN, err)
 				return nil, mapper.ErrNotMapped
 			}
 			if matched {
 				logrus.Infof("found matching identity with wild pattern %s", ri.Status.CanonicalARN)
 				return &config.IdentityMapping{
 					IdentityARN: canonicalARN,
+					Name:        ri.Name,
 					Username:    ri.Spec.Username,
 					Groups:      ri.Spec.Groups,
 				}, nil
@@ -161,6 +178,47 @@ func (m *CRDMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 	return nil, mapper.ErrNotMapped
 }
 
-func (m *CRDMapper) IsAccountAllowed(accountID string) bool {
-	return false
+func (m *CRDMapper) MapAccount(accountID string) (*config.IdentityMapping, error) {
+	return nil, mapper.ErrNotMapped
+}
+
+func matchesWildMapping(ri *ramauthenticatorv1alpha1.RAMIdentityMapping, canonicalARN string) (bool, error) {
+	return regexp.MatchString(ri.Status.CanonicalARN, canonicalARN)
+}
+
+// ListMappings returns the synced RAMIdentityMappings. Wildcard mappings
+// match the way Map matches them.
+func (m *CRDMapper) ListMappings() []mapper.ListedMapping {
+	var wildMappingCache controller.WildMappingMap
+	if m.Controller != nil {
+		wildMappingCache, _ = m.WildMappingCache.Load().(controller.WildMappingMap)
+	}
+	var mappings []mapper.ListedMapping
+	for _, obj := range m.ramMappingsIndex.List() {
+		ri, ok := obj.(*ramauthenticatorv1alpha1.RAMIdentityMapping)
+		if !ok || ri.Status.CanonicalARN == "" {
+			continue
+		}
+		listed := mapper.ListedMapping{
+			ARN:      ri.Status.CanonicalARN,
+			Object:   ri.Name,
+			Username: ri.Spec.Username,
+			Groups:   ri.Spec.Groups,
+		}
+		if wild, ok := wildMappingCache[ri.Name]; ok {
+			listed.Matches = func(canonicalARN string) bool {
+				matched, err := matchesWildMapping(wild, canonicalARN)
+				return err == nil && matched
+			}
+		}
+		mappings = append(mappings, listed)
+	}
+	return mappings
+}
+
+// ReportConflict emits a Warning Event on the RAMIdentityMapping.
+func (m *CRDMapper) ReportConflict(object string, message string) {
+	if m.Controller != nil {
+		m.RecordWarning(object, controller.MappingConflict, message)
+	}
 }
@@ -0,0 +1,69 @@
+package crd
+
+import (
+	"fmt"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DenyRulePluralName   = "ramdenyrules"
+	DenyRuleSingularName = "ramdenyrule"
+	DenyRuleKind         = "RAMDenyRule"
+	DenyRuleListKind     = "RAMDenyRuleList"
+	AccessKeyID          = "accessKeyID"
+	AccountID            = "accountID"
+	SessionName          = "sessionName"
+	Reason               = "reason"
+)
+
+func getRamDenyRuleCRD() apiext.CustomResourceDefinition {
+	properties := map[string]apiext.JSONSchemaProps{}
+	for _, field := range []string{AccessKeyID, Arn, UserID, AccountID, SessionName, Reason} {
+		properties[field] = apiext.JSONSchemaProps{Type: "string"}
+	}
+	return apiext.CustomResourceDefinition{
+		ObjectMeta: meta.ObjectMeta{
+			Name: fmt.Sprintf("%s.%s", DenyRulePluralName, GroupName),
+		},
+		Spec: apiext.CustomResourceDefinitionSpec{
+			Scope: apiext.ClusterScoped,
+			Names: apiext.CustomResourceDefinitionNames{
+				Plural:   DenyRulePluralName,
+				Kind:     DenyRuleKind,
+				Singular: DenyRuleSingularName,
+				ListKind: DenyRuleListKind,
+			},
+			Group: GroupName,
+			Conversion: &apiext.CustomResourceConversion{
+				Strategy: apiext.NoneConverter,
+			},
+			Versions: []apiext.CustomResourceDefinitionVersion{
+				{
+					Name:    Version,
+					Served:  true,
+					Storage: true,
+					Schema: &apiext.CustomResourceValidation{
+						OpenAPIV3Schema: &apiext.JSONSchemaProps{
+							Type: Object,
+							Properties: map[string]apiext.JSONSchemaProps{
+								Spec: {
+									Properties: properties,
+									Type:       Object,
+								},
+							},
+							Required: []string{Spec},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// SyncDenyRuleCRD creates or updates the RAMDenyRule CRD of the deny list.
+func SyncDenyRuleCRD(apiExtClientSet apiextcs.Interface) error {
+	return syncCRD(apiExtClientSet, getRamDenyRuleCRD())
+}
@@ -109,13 +109,17 @@ func isActivating(crd *apiext.CustomResourceDefinition) bool {
 }
 
 func SyncCRD(apiExtClientSet apiextcs.Interface) error {
-	ramIdentityMappingCRD := getRamIdentityMappingCRD()
+	return syncCRD(apiExtClientSet, getRamIdentityMappingCRD())
+}
+
+// syncCRD creates or updates crd and waits for it to be established.
+func syncCRD(apiExtClientSet apiextcs.Interface, crd apiext.CustomResourceDefinition) error {
 	var crdCli = apiExtClientSet.ApiextensionsV1().CustomResourceDefinitions()
-	var crdActual, err = crdCli.Get(context.TODO(), ramIdentityMappingCRD.Name, meta.GetOptions{})
+	var crdActual, err = crdCli.Get(context.TODO(), crd.Name, meta.GetOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	var crdExpected = ramIdentityMappingCRD.DeepCopy()
+	var crdExpected = crd.DeepCopy()
 
 	if !isActivating(crdActual) {
 		// create if not found
@@ -127,7 +131,7 @@ func SyncCRD(apiExtClientSet apiextcs.Interface) error {
 			return err
 		}
 		// fetch again to confirm
-		crdActual, err = crdCli.Get(context.TODO(), ramIdentityMappingCRD.Name, meta.GetOptions{})
+		crdActual, err = crdCli.Get(context.TODO(), crd.Name, meta.GetOptions{})
 		if err != nil {
 			return err
 		}
@@ -146,7 +150,7 @@ func SyncCRD(apiExtClientSet apiextcs.Interface) error {
 	var timeCtx, timeCancel = context.WithTimeout(context.Background(), time.Minute)
 	defer timeCancel()
 	return wait.PollImmediateUntil(5*time.Second, func() (bool, error) {
-		var crdActual, err = crdCli.Get(context.TODO(), ramIdentityMappingCRD.Name, meta.GetOptions{})
+		var crdActual, err = crdCli.Get(context.TODO(), crd.Name, meta.GetOptions{})
 		if err != nil && !apierrors.IsNotFound(err) {
 			return false, err
 		}
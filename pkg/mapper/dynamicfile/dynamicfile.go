@@ -11,18 +11,22 @@ import (
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/arn"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type DynamicFileMapStore struct {
-	mutex sync.RWMutex
-	users map[string]config.UserMapping
-	roles map[string]config.RoleMapping
-	// Used as set.
-	aliAccounts map[string]interface{}
+	mutex       sync.RWMutex
+	users       map[string]config.UserMapping
+	roles       map[string]config.RoleMapping
+	aliAccounts map[string]config.AccountMapping
 	filename    string
+	// loaded is true once the file has been parsed successfully
+	loaded bool
+	// loadErr is the error of the last failed load
+	loadErr error
 }
 
 type DynamicFileData struct {
@@ -35,7 +39,7 @@ type DynamicFileData struct {
 	UserMappings []config.UserMapping `json:"mapUsers"`
 	// AutoMappedAlibabaCloudAccounts is a list of Alibaba Cloud accounts that are allowed without an explicit user/role mapping.
 	// RAM ARN from these accounts automatically maps to the Kubernetes username.
-	AutoMappedAlibabaCloudAccounts []string `json:"mapAccounts"`
+	AutoMappedAlibabaCloudAccounts []config.AccountMapping `json:"mapAccounts"`
 }
 
 type ErrParsingMap struct {
@@ -62,6 +66,7 @@ func (m *DynamicFileMapStore) loadDynamicFile() error {
 	err := waitUntilFileAvailable(m.filename)
 	if err != nil {
 		logrus.Errorf("LoadDynamicFile: failed to wait till dynamic file available %v", err)
+		m.setLoadError(err)
 		return err
 	}
 	logrus.Infof("LoadDynamicFile: %v is available. loading", m.filename)
@@ -69,6 +74,7 @@ func (m *DynamicFileMapStore) loadDynamicFile() error {
 	userMappings, roleMappings, aliAccounts, err := ParseMap(m.filename)
 	if err != nil {
 		logrus.Errorf("LoadDynamicFile: There was an error parsing the dynamic file: %+v. Map is not updated. Please correct dynamic file", err)
+		m.setLoadError(err)
 		return err
 	} else {
 		m.saveMap(userMappings, roleMappings, aliAccounts)
@@ -76,6 +82,26 @@ func (m *DynamicFileMapStore) loadDynamicFile() error {
 	return nil
 }
 
+func (m *DynamicFileMapStore) setLoadError(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.loadErr = err
+}
+
+// Ready returns nil once the dynamic file has been loaded. Later failed
+// reloads keep serving the previous mappings and do not affect readiness.
+func (m *DynamicFileMapStore) Ready() error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.loaded {
+		return nil
+	}
+	if m.loadErr != nil {
+		return m.loadErr
+	}
+	return fmt.Errorf("waiting for %s to be available", m.filename)
+}
+
 func NewDynamicFileMapStore(filename string) (*DynamicFileMapStore, error) {
 	ms := DynamicFileMapStore{}
 	ms.filename = filename
@@ -115,7 +141,7 @@ func (m *DynamicFileMapStore) startLoadDynamicFile(stopCh <-chan struct{}) {
 						// reset memory
 						userMappings := make([]config.UserMapping, 0)
 						roleMappings := make([]config.RoleMapping, 0)
-						aliAccounts := make([]string, 0)
+						aliAccounts := make([]config.AccountMapping, 0)
 						m.saveMap(userMappings, roleMappings, aliAccounts)
 					}
 					return
@@ -127,7 +153,7 @@ func (m *DynamicFileMapStore) startLoadDynamicFile(stopCh <-chan struct{}) {
 	}, time.Second, stopCh)
 }
 
-func ParseMap(filename string) (userMappings []config.UserMapping, roleMappings []config.RoleMapping, aliAccounts []string, err error) {
+func ParseMap(filename string) (userMappings []config.UserMapping, roleMappings []config.RoleMapping, aliAccounts []config.AccountMapping, err error) {
 	errs := make([]error, 0)
 	userMappings = make([]config.UserMapping, 0)
 	roleMappings = make([]config.RoleMapping, 0)
@@ -149,22 +175,28 @@ func ParseMap(filename string) (userMappings []config.UserMapping, roleMappings
 	}
 
 	for _, userMapping := range dynamicFileData.UserMappings {
-		if userMapping.UserARN == "" {
-			errs = append(errs, fmt.Errorf("Value for userarn must be supplied"))
+		if err := userMapping.Validate(); err != nil {
+			errs = append(errs, err)
 		} else {
 			userMappings = append(userMappings, userMapping)
 		}
 	}
 
 	for _, roleMapping := range dynamicFileData.RoleMappings {
-		if roleMapping.RoleARN == "" {
-			errs = append(errs, fmt.Errorf("Value for rolearn must be supplied"))
+		if err := roleMapping.Validate(); err != nil {
+			errs = append(errs, err)
 		} else {
 			roleMappings = append(roleMappings, roleMapping)
 		}
 	}
 
-	aliAccounts = dynamicFileData.AutoMappedAlibabaCloudAccounts[:]
+	for _, accountMapping := range dynamicFileData.AutoMappedAlibabaCloudAccounts {
+		if err := accountMapping.Validate(); err != nil {
+			errs = append(errs, err)
+		} else {
+			aliAccounts = append(aliAccounts, accountMapping)
+		}
+	}
 
 	if len(errs) > 0 {
 		logrus.Warnf("ParseMap: Errors parsing dynamic file: %+v", errs)
@@ -175,13 +207,15 @@ func ParseMap(filename string) (userMappings []config.UserMapping, roleMappings
 func (ms *DynamicFileMapStore) saveMap(
 	userMappings []config.UserMapping,
 	roleMappings []config.RoleMapping,
-	aliAccounts []string) {
+	aliAccounts []config.AccountMapping) {
 
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 	ms.users = make(map[string]config.UserMapping)
 	ms.roles = make(map[string]config.RoleMapping)
-	ms.aliAccounts = make(map[string]interface{})
+	ms.aliAccounts = make(map[string]config.AccountMapping)
+	ms.loaded = true
+	ms.loadErr = nil
 
 	for _, user := range userMappings {
 		canonicalizedARN, _ := arn.Canonicalize(strings.ToLower(user.UserARN))
@@ -192,7 +226,7 @@ func (ms *DynamicFileMapStore) saveMap(
 		ms.roles[canonicalizedARN] = role
 	}
 	for _, aliAccount := range aliAccounts {
-		ms.aliAccounts[aliAccount] = nil
+		ms.aliAccounts[aliAccount.AccountID] = aliAccount
 	}
 }
 
@@ -222,11 +256,11 @@ func (ms *DynamicFileMapStore) RoleMapping(arn string) (config.RoleMapping, erro
 	}
 }
 
-func (ms *DynamicFileMapStore) AliAccount(id string) bool {
+func (ms *DynamicFileMapStore) AliAccount(id string) (config.AccountMapping, bool) {
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
-	_, ok := ms.aliAccounts[id]
-	return ok
+	account, ok := ms.aliAccounts[id]
+	return account, ok
 }
 
 func (ms *DynamicFileMapStore) LogMapping() {
@@ -238,7 +272,21 @@ func (ms *DynamicFileMapStore) LogMapping() {
 	for _, role := range ms.roles {
 		logrus.Info(role)
 	}
-	for aliAccount, _ := range ms.aliAccounts {
+	for _, aliAccount := range ms.aliAccounts {
 		logrus.Info(aliAccount)
 	}
 }
+
+// ListMappings returns the role and user mappings of the dynamic file.
+func (ms *DynamicFileMapStore) ListMappings() []mapper.ListedMapping {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	var mappings []mapper.ListedMapping
+	for key, role := range ms.roles {
+		mappings = append(mappings, mapper.ListedMapping{ARN: key, Username: role.Username, Groups: role.Groups})
+	}
+	for key, user := range ms.users {
+		mappings = append(mappings, mapper.ListedMapping{ARN: key, Username: user.Username, Groups: user.Groups})
+	}
+	return mappings
+}
@@ -37,6 +37,7 @@ func (m *DynamicFileMapper) Map(canonicalARN string) (*config.IdentityMapping, e
 	if err == nil {
 		return &config.IdentityMapping{
 			IdentityARN: canonicalARN,
+			Name:        rm.RoleARN,
 			Username:    rm.Username,
 			Groups:      rm.Groups,
 		}, nil
@@ -46,6 +47,7 @@ func (m *DynamicFileMapper) Map(canonicalARN string) (*config.IdentityMapping, e
 	if err == nil {
 		return &config.IdentityMapping{
 			IdentityARN: canonicalARN,
+			Name:        um.UserARN,
 			Username:    um.Username,
 			Groups:      um.Groups,
 		}, nil
@@ -54,6 +56,9 @@ func (m *DynamicFileMapper) Map(canonicalARN string) (*config.IdentityMapping, e
 	return nil, mapper.ErrNotMapped
 }
 
-func (m *DynamicFileMapper) IsAccountAllowed(accountID string) bool {
-	return m.AliAccount(accountID)
+func (m *DynamicFileMapper) MapAccount(accountID string) (*config.IdentityMapping, error) {
+	if accountMapping, ok := m.AliAccount(accountID); ok {
+		return accountMapping.IdentityMapping(), nil
+	}
+	return nil, mapper.ErrNotMapped
 }
@@ -2,6 +2,7 @@ package file
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/arn"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
@@ -11,7 +12,7 @@ import (
 type FileMapper struct {
 	roleMap    map[string]config.RoleMapping
 	userMap    map[string]config.UserMapping
-	accountMap map[string]bool
+	accountMap map[string]config.AccountMapping
 }
 
 var _ mapper.Mapper = &FileMapper{}
@@ -20,7 +21,7 @@ func NewFileMapper(cfg config.Config) (*FileMapper, error) {
 	fileMapper := &FileMapper{
 		roleMap:    make(map[string]config.RoleMapping),
 		userMap:    make(map[string]config.UserMapping),
-		accountMap: make(map[string]bool),
+		accountMap: make(map[string]config.AccountMapping),
 	}
 
 	for _, m := range cfg.RoleMappings {
@@ -48,7 +49,10 @@ func NewFileMapper(cfg config.Config) (*FileMapper, error) {
 		fileMapper.userMap[key] = m
 	}
 	for _, m := range cfg.AutoMappedAlibabaCloudAccounts {
-		fileMapper.accountMap[m] = true
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		fileMapper.accountMap[m.AccountID] = m
 	}
 
 	return fileMapper, nil
@@ -57,7 +61,7 @@ func NewFileMapper(cfg config.Config) (*FileMapper, error) {
 func NewFileMapperWithMaps(
 	lowercaseRoleMap map[string]config.RoleMapping,
 	lowercaseUserMap map[string]config.UserMapping,
-	accountMap map[string]bool) *FileMapper {
+	accountMap map[string]config.AccountMapping) *FileMapper {
 	return &FileMapper{
 		roleMap:    lowercaseRoleMap,
 		userMap:    lowercaseUserMap,
@@ -73,11 +77,16 @@ func (m *FileMapper) Start(_ <-chan struct{}) error {
 	return nil
 }
 
+func (m *FileMapper) Ready() error {
+	return nil
+}
+
 func (m *FileMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 	for _, roleMapping := range m.roleMap {
 		if roleMapping.Matches(canonicalARN) {
 			return &config.IdentityMapping{
 				IdentityARN: canonicalARN,
+				Name:        roleMapping.RoleARN,
 				Username:    roleMapping.Username,
 				Groups:      roleMapping.Groups,
 			}, nil
@@ -88,6 +97,7 @@ func (m *FileMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 		if userMapping.Matches(canonicalARN) {
 			return &config.IdentityMapping{
 				IdentityARN: canonicalARN,
+				Name:        userMapping.UserARN,
 				Username:    userMapping.Username,
 				Groups:      userMapping.Groups,
 			}, nil
@@ -97,6 +107,21 @@ func (m *FileMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
 	return nil, mapper.ErrNotMapped
 }
 
-func (m *FileMapper) IsAccountAllowed(accountID string) bool {
-	return m.accountMap[accountID]
+func (m *FileMapper) MapAccount(accountID string) (*config.IdentityMapping, error) {
+	if accountMapping, ok := m.accountMap[accountID]; ok {
+		return accountMapping.IdentityMapping(), nil
+	}
+	return nil, mapper.ErrNotMapped
+}
+
+// ListMappings returns the role and user mappings of the configuration file.
+func (m *FileMapper) ListMappings() []mapper.ListedMapping {
+	var mappings []mapper.ListedMapping
+	for _, roleMapping := range m.roleMap {
+		mappings = append(mappings, mapper.ListedMapping{ARN: strings.ToLower(roleMapping.RoleARN), Username: roleMapping.Username, Groups: roleMapping.Groups})
+	}
+	for _, userMapping := range m.userMap {
+		mappings = append(mappings, mapper.ListedMapping{ARN: strings.ToLower(userMapping.UserARN), Username: userMapping.Username, Groups: userMapping.Groups})
+	}
+	return mappings
 }
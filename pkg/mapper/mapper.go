@@ -33,6 +33,16 @@ var (
 	BackendModeChoices = []string{ModeMountedFile, ModeACKConfigMap, ModeCRD, ModeDynamicFile}
 )
 
+const (
+	// ChainPolicyFirst maps an identity with the first backend that maps it.
+	ChainPolicyFirst string = "first"
+	// ChainPolicyMerge maps an identity with every backend that maps it: the
+	// username of the first one and the union of all their groups.
+	ChainPolicyMerge string = "merge"
+)
+
+var ChainPolicyChoices = []string{ChainPolicyFirst, ChainPolicyMerge}
+
 var ErrNotMapped = errors.New("ARN is not mapped")
 
 type Mapper interface {
@@ -40,7 +50,13 @@ type Mapper interface {
 	// Start must be non-blocking
 	Start(stopCh <-chan struct{}) error
 	Map(canonicalARN string) (*config.IdentityMapping, error)
-	IsAccountAllowed(accountID string) bool
+	// MapAccount returns the mapping of identities of an auto-mapped
+	// account, or ErrNotMapped. It is only consulted once no mapper maps
+	// the identity's ARN.
+	MapAccount(accountID string) (*config.IdentityMapping, error)
+	// Ready returns nil once the mapper has loaded its mappings and can
+	// serve lookups, or an error describing why it cannot.
+	Ready() error
 }
 
 func ValidateBackendMode(modes []string) []error {
@@ -69,3 +85,12 @@ func ValidateBackendMode(modes []string) []error {
 
 	return errs
 }
+
+func ValidateChainPolicy(policy string) error {
+	for _, choice := range ChainPolicyChoices {
+		if policy == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("mapper-chain-policy %q is not one of %q", policy, ChainPolicyChoices)
+}
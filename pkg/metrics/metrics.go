@@ -11,7 +11,19 @@ const (
 	Invalid   = "invalid_token"
 	STSError  = "sts_error"
 	Unknown   = "uknown_user"
+	Throttled = "throttled"
 	Success   = "success"
+	Denied    = "denied"
+	Replayed  = "replayed"
+
+	// results of token cache lookups
+	CacheHit         = "hit"
+	CacheNegativeHit = "negative_hit"
+	CacheMiss        = "miss"
+
+	// results of certificate reloads
+	ReloadSuccess = "success"
+	ReloadFailure = "failure"
 )
 
 var authenticatorMetrics Metrics
@@ -38,6 +50,20 @@ type Metrics struct {
 	Latency                *prometheus.HistogramVec
 	StsConnectionFailure   prometheus.Counter
 	StsResponses           *prometheus.CounterVec
+	StsRetries             prometheus.Counter
+	StsEndpointRequests    *prometheus.CounterVec
+	StsEndpointCircuitOpen *prometheus.GaugeVec
+	TokenCacheRequests     *prometheus.CounterVec
+	TokenCacheEvictions    *prometheus.CounterVec
+	TokenVerifyCoalesced   prometheus.Counter
+	TokenNonceEvictions    prometheus.Counter
+	CertificateNotAfter    prometheus.Gauge
+	CertificateReloads     *prometheus.CounterVec
+	CertificateLastReload  prometheus.Gauge
+	TLSPolicyInfo          *prometheus.GaugeVec
+	MappingConflicts       *prometheus.GaugeVec
+	AuditEventsDropped     *prometheus.CounterVec
+	DenyListLoadFailures   *prometheus.CounterVec
 }
 
 func createMetrics(reg prometheus.Registerer) Metrics {
@@ -65,6 +91,104 @@ func createMetrics(reg prometheus.Registerer) Metrics {
 				Help:      "Sts responses with error code label",
 			}, []string{"ResponseCode"},
 		),
+		StsRetries: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sts_retries_total",
+				Help:      "Sts calls retried after a transport or server error",
+			},
+		),
+		StsEndpointRequests: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sts_endpoint_requests_total",
+				Help:      "Sts calls by endpoint and result, failures being transport or server errors",
+			}, []string{"endpoint", "result"},
+		),
+		StsEndpointCircuitOpen: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "sts_endpoint_circuit_open",
+				Help:      "Whether the circuit breaker of an sts endpoint is open (1) or closed (0)",
+			}, []string{"endpoint"},
+		),
+		TokenCacheRequests: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "token_cache_requests_total",
+				Help:      "Verified token cache lookups by result",
+			}, []string{"result"},
+		),
+		TokenCacheEvictions: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "token_cache_evictions_total",
+				Help:      "Verified token cache evictions by reason",
+			}, []string{"reason"},
+		),
+		TokenVerifyCoalesced: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "token_verify_coalesced_total",
+				Help:      "Token verifications that shared an in-flight STS call for the same token",
+			},
+		),
+		TokenNonceEvictions: factory.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "token_nonce_evictions_total",
+				Help:      "Unexpired signature nonces forgotten because the nonce store was full",
+			},
+		),
+		CertificateNotAfter: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "serving_certificate_not_after_seconds",
+				Help:      "Expiration of the loaded serving certificate as a unix timestamp",
+			},
+		),
+		CertificateReloads: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "serving_certificate_reloads_total",
+				Help:      "Serving certificate reloads by result",
+			}, []string{"result"},
+		),
+		CertificateLastReload: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "serving_certificate_last_reload_success",
+				Help:      "Whether the last serving certificate reload succeeded (1) or failed (0)",
+			},
+		),
+		TLSPolicyInfo: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "tls_policy_info",
+				Help:      "TLS policy of the webhook listener, always 1",
+			}, []string{"min_version", "max_version", "cipher_suites", "curves"},
+		),
+		MappingConflicts: factory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "mapping_conflicts",
+				Help:      "Mappings of the same ARN across the backends found by the last check, by kind",
+			}, []string{"kind"},
+		),
+		AuditEventsDropped: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "audit_events_dropped_total",
+				Help:      "Audit events that could not be written, by sink",
+			}, []string{"sink"},
+		),
+		DenyListLoadFailures: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "deny_list_load_failures_total",
+				Help:      "Deny list loads that kept the previous rules or left out invalid ones, by source",
+			}, []string{"source"},
+		),
 		Latency: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: Namespace,
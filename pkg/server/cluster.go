@@ -0,0 +1,60 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
+)
+
+// cluster is the mapper chain and settings of one of the cluster IDs served.
+type cluster struct {
+	id               string
+	audiences        []string
+	mappers          []mapper.Mapper
+	scrubbedAccounts []string
+}
+
+// buildClusters builds the mapper chain of the server's cluster and of each
+// of its additional Clusters.
+func buildClusters(cfg config.Config) ([]*cluster, error) {
+	var clusters []*cluster
+	for _, clusterCfg := range cfg.ClusterConfigs() {
+		mappers, err := BuildMapperChain(clusterCfg)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %v", clusterCfg.ClusterID, err)
+		}
+		clusters = append(clusters, &cluster{
+			id:               clusterCfg.ClusterID,
+			audiences:        clusterCfg.Audiences,
+			mappers:          mappers,
+			scrubbedAccounts: clusterCfg.ScrubbedAliyunAccounts,
+		})
+	}
+	return clusters, nil
+}
+
+// mapperName names a mapper in logs and health checks, prefixed with its
+// cluster ID when several clusters are served.
+func mapperName(clusters []*cluster, c *cluster, m mapper.Mapper) string {
+	if len(clusters) > 1 {
+		return c.id + "/" + m.Name()
+	}
+	return m.Name()
+}
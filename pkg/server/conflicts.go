@@ -0,0 +1,85 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+// conflictDetector periodically compares the mappings of all backends of
+// each cluster. Each conflict is logged, and reported on the objects involved,
+// when it is first seen; the gauge always reflects the last check.
+type conflictDetector struct {
+	clusters []*cluster
+	policy   string
+	reported map[string]bool
+}
+
+func newConflictDetector(clusters []*cluster, policy string) *conflictDetector {
+	return &conflictDetector{
+		clusters: clusters,
+		policy:   policy,
+		reported: map[string]bool{},
+	}
+}
+
+func (d *conflictDetector) check() {
+	counts := map[string]int{}
+	seen := map[string]bool{}
+	for _, cl := range d.clusters {
+		for _, conflict := range mapper.DetectConflicts(cl.mappers, d.policy) {
+			counts[conflict.Kind]++
+			message := conflict.String()
+			key := cl.id + "/" + message
+			seen[key] = true
+			if d.reported[key] {
+				continue
+			}
+			fields := logrus.Fields{
+				"kind": conflict.Kind,
+				"arn":  conflict.ARN,
+			}
+			if len(d.clusters) > 1 {
+				fields["cluster"] = cl.id
+			}
+			logrus.WithFields(fields).Warn(message)
+			report(cl.mappers, conflict, message)
+		}
+	}
+	d.reported = seen
+
+	for _, kind := range mapper.ConflictKinds {
+		metrics.Get().MappingConflicts.WithLabelValues(kind).Set(float64(counts[kind]))
+	}
+}
+
+// report surfaces a conflict on the objects of the backends that support it.
+func report(mappers []mapper.Mapper, conflict mapper.Conflict, message string) {
+	for _, entry := range conflict.Entries {
+		if entry.Object == "" {
+			continue
+		}
+		for _, m := range mappers {
+			if reporter, ok := m.(mapper.ConflictReporter); ok && m.Name() == entry.Backend {
+				reporter.ReportConflict(entry.Object, message)
+			}
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package server
 
+import (
+	"fmt"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/denylist"
+)
+
 type MappingError struct {
 	err error
 }
@@ -11,3 +17,19 @@ func NewMappingError(err error) MappingError {
 func (m MappingError) Error() string {
 	return m.err.Error()
 }
+
+// DeniedError is returned for identities matching a deny rule.
+type DeniedError struct {
+	rule denylist.Rule
+}
+
+func NewDeniedError(rule denylist.Rule) DeniedError {
+	return DeniedError{rule: rule}
+}
+
+func (d DeniedError) Error() string {
+	if d.rule.Reason == "" {
+		return fmt.Sprintf("denied by rule %s", d.rule)
+	}
+	return fmt.Sprintf("denied by rule %s: %s", d.rule, d.rule.Reason)
+}
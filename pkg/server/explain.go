@@ -0,0 +1,353 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/arn"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/audit"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+)
+
+// ExplainPath is the path of the endpoint explaining how an identity is mapped.
+const ExplainPath = "/debug/explain"
+
+const (
+	phaseARN     = "arn"
+	phaseAccount = "account"
+
+	// StepHit, StepMiss, StepError and StepSkipped are the results of a
+	// MappingStep. Skipped backends were not consulted because an earlier
+	// backend or phase decided the mapping.
+	StepHit     = "hit"
+	StepMiss    = "miss"
+	StepError   = "error"
+	StepSkipped = "skipped"
+)
+
+// ExplainRequest asks how an ARN, optionally with a session name, or the
+// identity of a token would be mapped. An ARN is mapped in ClusterID, by
+// default the server's own cluster.
+type ExplainRequest struct {
+	ARN         string `json:"arn,omitempty"`
+	SessionName string `json:"sessionName,omitempty"`
+	ClusterID   string `json:"clusterID,omitempty"`
+	Token       string `json:"token,omitempty"`
+}
+
+// Explanation is how an identity is mapped, step by step.
+type Explanation struct {
+	ClusterID    string `json:"clusterID"`
+	ARN          string `json:"arn"`
+	CanonicalARN string `json:"canonicalARN"`
+	AccountID    string `json:"accountID"`
+	SessionName  string `json:"sessionName,omitempty"`
+	ChainPolicy  string `json:"chainPolicy"`
+	// Steps are the lookups in chain order, first of the ARN, then of the
+	// account.
+	Steps []MappingStep `json:"steps"`
+	// WildcardMatches are the wildcard mappings whose pattern matches the
+	// canonical ARN. They are only used by a backend without an exact
+	// mapping of the ARN.
+	WildcardMatches []WildcardMatch `json:"wildcardMatches,omitempty"`
+	Authenticated   bool            `json:"authenticated"`
+	// Error is why the identity is denied.
+	Error string `json:"error,omitempty"`
+	// User is the user the TokenReview would return.
+	User *authenticationv1.UserInfo `json:"user,omitempty"`
+}
+
+// MappingStep is one lookup of an identity in a backend.
+type MappingStep struct {
+	Phase   string `json:"phase"`
+	Backend string `json:"backend"`
+	Result  string `json:"result"`
+	Error   string `json:"error,omitempty"`
+	// Username and Groups are the templates of the mapping found.
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// WildcardMatch is a wildcard mapping matching an ARN.
+type WildcardMatch struct {
+	Backend  string   `json:"backend"`
+	Object   string   `json:"object,omitempty"`
+	Pattern  string   `json:"pattern"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// mappingTrace records the lookups of doTracedMapping. A nil trace records
+// nothing.
+type mappingTrace struct {
+	steps []MappingStep
+}
+
+func (t *mappingTrace) add(phase, backend string, mapping *config.IdentityMapping, err error) {
+	if t == nil {
+		return
+	}
+	step := MappingStep{Phase: phase, Backend: backend, Result: StepHit}
+	switch {
+	case err == mapper.ErrNotMapped:
+		step.Result = StepMiss
+	case err != nil:
+		step.Result = StepError
+		step.Error = err.Error()
+	}
+	if mapping != nil {
+		step.Username = mapping.Username
+		step.Groups = mapping.Groups
+	}
+	t.steps = append(t.steps, step)
+}
+
+func (t *mappingTrace) skip(phase string, mappers []mapper.Mapper) {
+	if t == nil {
+		return
+	}
+	for _, m := range mappers {
+		t.steps = append(t.steps, MappingStep{Phase: phase, Backend: m.Name(), Result: StepSkipped})
+	}
+}
+
+// explainEndpoint explains how the requested identity is mapped to callers
+// whose bearer token maps to one of the explain groups. Callers and the
+// tokens they explain are rate limited, replay protected, checked against
+// the deny list and audited like TokenReviews.
+func (h *handler) explainEndpoint(w http.ResponseWriter, req *http.Request) {
+	log := logrus.WithFields(logrus.Fields{
+		"path":   req.URL.Path,
+		"client": req.RemoteAddr,
+	})
+	event := &audit.Event{Time: time.Now(), Client: req.RemoteAddr, Path: req.URL.Path}
+	defer h.audit(event)
+	if req.Method != http.MethodPost {
+		event.Result, event.Reason = metrics.Malformed, "unexpected request method"
+		http.Error(w, "expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	caller, status, err := h.authorizeExplain(req, event)
+	if err != nil {
+		event.Reason = err.Error()
+		log.WithError(err).Warn("explain denied")
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	log = log.WithField("caller", caller)
+
+	var explainRequest ExplainRequest
+	if req.Body == nil || json.NewDecoder(req.Body).Decode(&explainRequest) != nil {
+		event.Result, event.Reason = metrics.Malformed, "could not parse request body"
+		http.Error(w, "expected a request body to be an ExplainRequest", http.StatusBadRequest)
+		return
+	}
+	if (explainRequest.ARN == "") == (explainRequest.Token == "") {
+		event.Result, event.Reason = metrics.Malformed, "exactly one of arn and token must be set"
+		http.Error(w, "exactly one of arn and token must be set", http.StatusBadRequest)
+		return
+	}
+
+	explanation := h.explain(explainRequest, req.RemoteAddr)
+	log.WithFields(logrus.Fields{
+		"arn":           explanation.ARN,
+		"authenticated": explanation.Authenticated,
+	}).Info("explained mapping")
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(explanation); err != nil {
+		log.WithError(err).Error("could not write explanation")
+	}
+}
+
+// authorizeExplain verifies the caller's bearer token and returns its
+// username, or the HTTP status to deny it with. The caller is recorded in
+// event.
+func (h *handler) authorizeExplain(req *http.Request, event *audit.Event) (string, int, error) {
+	bearer := req.Header.Get("Authorization")
+	if !strings.HasPrefix(bearer, "Bearer ") {
+		event.Result = metrics.Malformed
+		return "", http.StatusUnauthorized, fmt.Errorf("no bearer token")
+	}
+	bearer = strings.TrimPrefix(bearer, "Bearer ")
+	event.TokenVersion = token.Version(bearer)
+	identity, result, err := h.verifyToken(bearer, req.RemoteAddr)
+	if err != nil {
+		event.Result = result
+		if result == metrics.Throttled {
+			return "", http.StatusTooManyRequests, err
+		}
+		return "", http.StatusUnauthorized, err
+	}
+	h.auditIdentity(event, identity)
+	if err := h.checkDenyList(identity); err != nil {
+		event.Result = metrics.Denied
+		return "", http.StatusForbidden, err
+	}
+	mapped, err := h.doMapping(identity)
+	if err != nil {
+		event.Result = metrics.Unknown
+		return "", http.StatusForbidden, err
+	}
+	event.Username = mapped.username
+	event.Groups = mapped.groups
+	for _, group := range mapped.groups {
+		for _, allowed := range h.explainGroups {
+			if group == allowed {
+				event.Result = metrics.Success
+				return mapped.username, 0, nil
+			}
+		}
+	}
+	event.Result = metrics.Denied
+	return "", http.StatusForbidden, fmt.Errorf("%s is not in any of the groups %q", mapped.username, h.explainGroups)
+}
+
+// explain maps the requested identity the way authenticateEndpoint does and
+// records each decision. An explained token is verified and audited like a
+// token presented by client.
+func (h *handler) explain(req ExplainRequest, client string) *Explanation {
+	explanation := &Explanation{ChainPolicy: h.chainPolicy}
+	if explanation.ChainPolicy == "" {
+		explanation.ChainPolicy = mapper.ChainPolicyFirst
+	}
+
+	clusterID := req.ClusterID
+	if clusterID == "" {
+		clusterID = h.clusterID
+	}
+	var identity *token.Identity
+	var err error
+	if req.Token != "" {
+		identity, err = h.verifyExplainedToken(req.Token, client)
+	} else if _, ok := h.clusters[clusterID]; !ok {
+		err = fmt.Errorf("cluster %s is not served", clusterID)
+	} else {
+		identity, err = identityFromARN(req.ARN, req.SessionName, clusterID)
+	}
+	if err != nil {
+		explanation.ClusterID = clusterID
+		explanation.ARN = req.ARN
+		explanation.Error = err.Error()
+		return explanation
+	}
+	explanation.ClusterID = identity.ClusterID
+	explanation.ARN = identity.ARN
+	explanation.CanonicalARN = identity.CanonicalARN
+	explanation.AccountID = identity.AccountID
+	explanation.SessionName = identity.SessionName
+	// a denied identity is not explained any further
+	if err := h.checkDenyList(identity); err != nil {
+		explanation.Error = err.Error()
+		return explanation
+	}
+	explanation.WildcardMatches = wildcardMatches(h.clusterOf(identity).mappers, strings.ToLower(identity.CanonicalARN))
+
+	trace := &mappingTrace{}
+	mapped, err := h.doTracedMapping(identity, trace)
+	explanation.Steps = trace.steps
+	if err != nil {
+		explanation.Error = NewMappingError(err).Error()
+		return explanation
+	}
+	user, err := h.userInfo(identity, mapped)
+	if err != nil {
+		explanation.Error = NewMappingError(err).Error()
+		return explanation
+	}
+	explanation.Authenticated = true
+	explanation.User = &user
+	return explanation
+}
+
+// verifyExplainedToken verifies a token to explain and audits its
+// verification. Unlike verifyToken, it neither records the use of the token
+// nor counts it against the rate limits, so that explaining a token does not
+// use it up; the caller was rate limited already.
+func (h *handler) verifyExplainedToken(tok, client string) (*token.Identity, error) {
+	event := &audit.Event{Time: time.Now(), Client: client, Path: ExplainPath, TokenVersion: token.Version(tok)}
+	defer h.audit(event)
+	identity, err := h.verifier.Verify(tok)
+	if err != nil {
+		event.Result = verificationResult(err)
+		event.Reason = err.Error()
+		return nil, err
+	}
+	event.Result = metrics.Success
+	h.auditIdentity(event, identity)
+	return identity, nil
+}
+
+func wildcardMatches(mappers []mapper.Mapper, canonicalARN string) []WildcardMatch {
+	var matches []WildcardMatch
+	for _, m := range mappers {
+		lister, ok := m.(mapper.MappingLister)
+		if !ok {
+			continue
+		}
+		for _, listed := range lister.ListMappings() {
+			if listed.Matches != nil && listed.Matches(canonicalARN) {
+				matches = append(matches, WildcardMatch{
+					Backend:  m.Name(),
+					Object:   listed.Object,
+					Pattern:  listed.ARN,
+					Username: listed.Username,
+					Groups:   listed.Groups,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// identityFromARN builds the identity STS would return for an ARN. The
+// session name of an assumed role ARN is taken from the ARN unless
+// sessionName is set. The user ID is unknown.
+func identityFromARN(rawARN, sessionName, clusterID string) (*token.Identity, error) {
+	canonicalARN, err := arn.Canonicalize(rawARN)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := utils.Parse(rawARN)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(parsed.Resource, "/")
+	if sessionName == "" && parts[0] == "assumed-role" {
+		sessionName = parts[len(parts)-1]
+	}
+	return &token.Identity{
+		ARN:          rawARN,
+		CanonicalARN: canonicalARN,
+		AccountID:    parsed.AccountID,
+		SessionName:  sessionName,
+		ClusterID:    clusterID,
+	}, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registerHealthChecks adds the health endpoints to mux:
+//
+//	/livez   reports that the process is alive
+//	/readyz  fails while shutting down or while any mapper is not ready,
+//	         append ?verbose to list the state of each backend
+//	/healthz is kept for compatibility and fails only while shutting down
+func (c *Server) registerHealthChecks(mux *http.ServeMux) {
+	mux.HandleFunc("/livez", c.livez)
+	mux.HandleFunc("/readyz", c.readyz)
+	mux.HandleFunc("/healthz", c.healthz)
+}
+
+func (c *Server) livez(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "ok")
+}
+
+func (c *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&c.draining) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok")
+}
+
+func (c *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	var report bytes.Buffer
+	ready := true
+
+	if atomic.LoadInt32(&c.draining) == 1 {
+		ready = false
+		fmt.Fprintf(&report, "[-]shutdown failed: shutting down\n")
+	} else {
+		fmt.Fprintf(&report, "[+]shutdown ok\n")
+	}
+	for _, cl := range c.clusters {
+		for _, m := range cl.mappers {
+			name := mapperName(c.clusters, cl, m)
+			if err := m.Ready(); err != nil {
+				ready = false
+				fmt.Fprintf(&report, "[-]mapper-%s failed: %v\n", name, err)
+			} else {
+				fmt.Fprintf(&report, "[+]mapper-%s ok\n", name)
+			}
+		}
+	}
+
+	_, verbose := r.URL.Query()["verbose"]
+	if !ready {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		report.WriteTo(w)
+		fmt.Fprintf(w, "readyz check failed\n")
+		return
+	}
+	if verbose {
+		report.WriteTo(w)
+		fmt.Fprintf(w, "readyz check passed\n")
+		return
+	}
+	fmt.Fprintf(w, "ok")
+}
+
+// newHealthHandler returns the handler of the plain HTTP health and metrics server.
+func (c *Server) newHealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	c.registerHealthChecks(mux)
+	mux.Handle("/metrics", promhttp.Handler())
+	if c.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
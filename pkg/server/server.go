@@ -17,63 +17,98 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/audit"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/certs"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/kubeconfig"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/denylist"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/ecs"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/httputil"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/configmap"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/crd"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/dynamicfile"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper/file"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/template"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
 	apiextcs "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/clientcmd"
 	"log"
+	"net"
 	"net/http"
-	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 )
 
-// tokenReviewDenyJSON is a static encoding (at init time) of the 'deny' TokenReview
-var tokenReviewDenyJSON = func() []byte {
-	res, err := json.Marshal(authenticationv1beta1.TokenReview{
-		Status: authenticationv1beta1.TokenReviewStatus{
-			Authenticated: false,
-		},
-	})
-	if err != nil {
-		logrus.WithError(err).Fatal("could not create static 'deny' JSON response")
-	}
-	return res
-}()
+// healthShutdownTimeout is how long the health server is given to shut down
+// once the webhook has drained.
+const healthShutdownTimeout = 5 * time.Second
 
-// Pattern to match EC2 instance IDs
-var (
-	instanceIDPattern = regexp.MustCompile("^i-(\\w{8}|\\w{17})$")
-)
+// handlerShutdownTimeout is how long handlers still running once their
+// connections were closed are given to return before the mappers and the
+// audit sink they use are stopped.
+const handlerShutdownTimeout = 5 * time.Second
+
+// maxRateLimitedClients bounds the number of client addresses tracked by the
+// rate limiter.
+const maxRateLimitedClients = 10000
+
+// maxRateLimitedAccessKeys bounds the number of access keys tracked by the
+// rate limiter.
+const maxRateLimitedAccessKeys = 10000
 
 // server state (internal)
 type handler struct {
 	http.ServeMux
-	verifier         token.Verifier
-	clusterID        string
-	mappers          []mapper.Mapper
-	scrubbedAccounts []string
+	verifier      token.Verifier
+	clusterID     string
+	clusters      map[string]*cluster
+	chainPolicy   string
+	clientLimiter *httputil.KeyedRateLimiter
+	// accessKeyLimiter only counts the AccessKeyIDs of verified tokens
+	accessKeyLimiter *httputil.KeyedRateLimiter
+	ecsResolver      ecs.Resolver
+	explainGroups    []string
+	uidStrategy      string
+	uidTemplate      string
+	extraKeys        []string
+	auditSink        audit.Sink
+	denyList         *denylist.List
+}
+
+// Options are the dependencies of a Server that can't be configured.
+type Options struct {
+	// NonceStore, if set, records the signature nonces of tokens instead of
+	// the in-process store sized by NonceStoreSize, for example to share them
+	// between replicas with NewExternalNonceStore.
+	NonceStore token.NonceStore
 }
 
 // New authentication webhook server.
-func New(cfg config.Config, stopCh <-chan struct{}) *Server {
+func New(cfg config.Config) *Server {
+	return NewWithOptions(cfg, Options{})
+}
+
+// NewWithOptions creates an authentication webhook server with options.
+func NewWithOptions(cfg config.Config, options Options) *Server {
 	c := &Server{
-		Config: cfg,
+		Config:     cfg,
+		stopCh:     make(chan struct{}),
+		nonceStore: options.NonceStore,
 	}
 	//ensure crd
 	k8sconfig, err := clientcmd.BuildConfigFromFlags(cfg.Master, cfg.Kubeconfig)
@@ -83,15 +118,32 @@ func New(cfg config.Config, stopCh <-chan struct{}) *Server {
 		logrus.Fatalf("failed to sync required crd: %v", err)
 	}
 
-	mappers, err := BuildMapperChain(cfg)
+	// clusters with their own kubeconfig need the crd as well
+	for _, cluster := range cfg.Clusters {
+		if cluster.Kubeconfig == "" || !hasBackendMode(cluster.BackendMode, mapper.ModeCRD) {
+			continue
+		}
+		k8sconfig, err := clientcmd.BuildConfigFromFlags("", cluster.Kubeconfig)
+		if err != nil {
+			logrus.Fatalf("invalid kubeconfig of cluster %s: %v", cluster.ClusterID, err)
+		}
+		if err := crd.SyncCRD(apiextcs.NewForConfigOrDie(k8sconfig)); err != nil {
+			logrus.Fatalf("failed to sync required crd in cluster %s: %v", cluster.ClusterID, err)
+		}
+	}
+
+	clusters, err := buildClusters(cfg)
 	if err != nil {
 		logrus.Fatalf("failed to build mapper chain: %v", err)
 	}
 
-	for _, m := range mappers {
-		logrus.Infof("starting mapper %q", m.Name())
-		if err := m.Start(stopCh); err != nil {
-			logrus.Fatalf("start mapper %q failed", m.Name())
+	for _, cl := range clusters {
+		for _, m := range cl.mappers {
+			name := mapperName(clusters, cl, m)
+			logrus.Infof("starting mapper %q", name)
+			if err := m.Start(c.stopCh); err != nil {
+				logrus.Fatalf("start mapper %q failed", name)
+			}
 		}
 	}
 
@@ -113,13 +165,46 @@ func New(cfg config.Config, stopCh <-chan struct{}) *Server {
 	}
 
 	for _, account := range c.AutoMappedAlibabaCloudAccounts {
-		logrus.WithField("accountID", account).Infof("mapping RAM Account")
+		logrus.WithFields(logrus.Fields{
+			"accountID": account.AccountID,
+			"username":  account.Username,
+			"groups":    account.Groups,
+		}).Infof("mapping RAM Account")
 	}
 
-	cert, err := c.GetOrCreateX509KeyPair()
+	denyList, err := denylist.New(denylist.Options{
+		File:               c.DenyListFile,
+		ConfigMap:          c.DenyListConfigMap,
+		ConfigMapNamespace: c.DenyListConfigMapNamespace,
+		CRD:                c.DenyListCRD,
+		Master:             c.Master,
+		Kubeconfig:         c.Kubeconfig,
+	})
 	if err != nil {
+		logrus.WithError(err).Fatal("could not create deny list")
+	}
+	if denyList != nil {
+		denyList.Start(c.stopCh)
+	}
+	c.denyList = denyList
+
+	if c.MappingConflictCheckInterval > 0 {
+		detector := newConflictDetector(clusters, c.MapperChainPolicy)
+		go wait.Until(detector.check, c.MappingConflictCheckInterval, c.stopCh)
+	}
+
+	if _, err := c.GetOrCreateX509KeyPair(); err != nil {
 		logrus.WithError(err).Fatalf("could not load/generate a certificate")
 	}
+	certWatcher, err := certs.NewCertificateWatcher(c.CertPath(), c.KeyPath())
+	if err != nil {
+		logrus.WithError(err).Fatalf("could not load the serving certificate")
+	}
+	go func() {
+		if err := certWatcher.Run(c.stopCh); err != nil {
+			logrus.WithError(err).Error("could not watch the serving certificate, it will not be reloaded")
+		}
+	}()
 
 	if !c.KubeconfigPregenerated {
 		if err := c.GenerateWebhookKubeconfig(); err != nil {
@@ -128,60 +213,241 @@ func New(cfg config.Config, stopCh <-chan struct{}) *Server {
 	}
 
 	// start a TLS listener with our custom certs
-	listener, err := tls.Listen("tcp", c.ListenAddr(), &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{*cert},
-	})
+	tlsConfig, err := c.tlsConfig(certWatcher)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not configure TLS")
+	}
+	listener, err := tls.Listen("tcp", c.ListenAddr(), tlsConfig)
 	if err != nil {
 		logrus.WithError(err).Fatal("could not open TLS listener")
 	}
 
+	auditSink, err := audit.NewSink(audit.Options{
+		LogPath:              c.AuditLogPath,
+		LogMaxSize:           c.AuditLogMaxSize,
+		LogMaxBackups:        c.AuditLogMaxBackups,
+		WebhookURL:           c.AuditWebhookURL,
+		WebhookBatchSize:     c.AuditWebhookBatchSize,
+		WebhookBatchInterval: c.AuditWebhookBatchInterval,
+	})
+	if err != nil {
+		logrus.WithError(err).Fatal("could not create audit sink")
+	}
+	c.auditSink = auditSink
+
 	// create a logrus logger for HTTP error logs
 	errLog := logrus.WithField("http", "error").Writer()
-	defer errLog.Close()
 
 	logrus.Infof("listening on %s", listener.Addr())
-	logrus.Infof("reconfigure your apiserver with `--authentication-token-webhook-config-file=%s` to enable (assuming default hostPath mounts)", c.GenerateKubeconfigPath)
+	for _, clusterCfg := range c.ClusterConfigs() {
+		logrus.Infof("reconfigure the apiserver of cluster %s with `--authentication-token-webhook-config-file=%s` and `--authentication-token-webhook-version=%s` to enable (assuming default hostPath mounts)", clusterCfg.ClusterID, clusterCfg.GenerateKubeconfigPath, kubeconfig.WebhookTokenReviewVersion)
+	}
 	c.httpServer = http.Server{
 		ErrorLog: log.New(errLog, "", 0),
-		Handler:  c.getHandler(mappers),
+		Handler:  c.trackInFlight(c.getHandler(clusters)),
 	}
 	c.listener = listener
+	c.errLog = errLog
+	c.clusters = clusters
+
+	if c.HealthPort > 0 {
+		healthListener, err := net.Listen("tcp", c.HealthListenAddr())
+		if err != nil {
+			logrus.WithError(err).Fatal("could not open health listener")
+		}
+		logrus.Infof("serving health checks and metrics on http://%s", healthListener.Addr())
+		c.healthServer = &http.Server{
+			ErrorLog: log.New(errLog, "", 0),
+			Handler:  c.newHealthHandler(),
+		}
+		c.healthListener = healthListener
+	}
 	return c
 }
 
-// Run will run the server closing the connection if there is a struct on the channel
+// Run will run the server until there is a struct on the channel, then drain
+// in-flight requests and return.
 func (c *Server) Run(stopCh <-chan struct{}) {
+	if c.healthServer != nil {
+		go func() {
+			if err := c.healthServer.Serve(c.healthListener); err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Fatal("health server exited")
+			}
+		}()
+	}
 
-	defer c.listener.Close()
-
+	serveErr := make(chan error, 1)
 	go func() {
-		http.ListenAndServe(":21363", &healthzHandler{})
+		serveErr <- c.httpServer.Serve(c.listener)
 	}()
-	if err := c.httpServer.Serve(c.listener); err != nil {
+
+	select {
+	case err := <-serveErr:
 		logrus.WithError(err).Fatal("http server exited")
+	case <-stopCh:
 	}
+	c.shutdown()
 }
 
-type healthzHandler struct{}
+// shutdown fails the readiness checks and keeps serving for ShutdownDelay
+// while the server is removed from the endpoints, then stops accepting new
+// connections and waits up to ShutdownGracePeriod for in-flight requests
+// before stopping the mappers, watchers and audit sink. Handlers still
+// running once their connections are closed are given another
+// handlerShutdownTimeout to return.
+func (c *Server) shutdown() {
+	atomic.StoreInt32(&c.draining, 1)
+	if c.ShutdownDelay > 0 {
+		logrus.Infof("shutting down, serving for %s until removed from the endpoints", c.ShutdownDelay)
+		time.Sleep(c.ShutdownDelay)
+	}
+	logrus.Infof("draining in-flight requests for up to %s", c.ShutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownGracePeriod)
+	defer cancel()
+	if err := c.httpServer.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("grace period expired, closing remaining connections")
+		c.httpServer.Close()
+	}
+	// closing the connections does not stop their handlers
+	if !waitTimeout(&c.inFlight, handlerShutdownTimeout) {
+		logrus.Warnf("requests still in flight after %s, stopping anyway", handlerShutdownTimeout)
+	}
+	if c.healthServer != nil {
+		healthCtx, healthCancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer healthCancel()
+		if err := c.healthServer.Shutdown(healthCtx); err != nil {
+			c.healthServer.Close()
+		}
+	}
 
-func (m *healthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "ok")
+	close(c.stopCh)
+	if c.auditSink != nil {
+		if err := c.auditSink.Close(); err != nil {
+			logrus.WithError(err).Error("could not close audit sink")
+		}
+	}
+	c.errLog.Close()
+	logrus.Info("server stopped")
 }
-func (c *Server) getHandler(mappers []mapper.Mapper) *handler {
+
+// trackInFlight counts the requests being handled, so that shutdown can wait
+// for them before stopping what they use.
+func (c *Server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.inFlight.Add(1)
+		defer c.inFlight.Done()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// waitTimeout waits for wg up to timeout and returns whether it is done.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (c *Server) getHandler(clusters []*cluster) *handler {
+	var clusterIDs []string
+	audiences := map[string][]string{}
+	clustersByID := map[string]*cluster{}
+	for _, cl := range clusters {
+		if cl.id != c.ClusterID {
+			clusterIDs = append(clusterIDs, cl.id)
+		}
+		audiences[cl.id] = cl.audiences
+		clustersByID[cl.id] = cl
+	}
+
+	nonceStore := c.nonceStore
+	if nonceStore != nil {
+		logrus.Info("will reject replayed tokens, remembering nonces in the configured store")
+	} else if c.NonceStoreSize > 0 {
+		logrus.Infof("will reject replayed tokens, remembering up to %d nonces", c.NonceStoreSize)
+		nonceStore = token.NewLocalNonceStore(c.NonceStoreSize)
+	}
+
+	verifier := token.NewVerifierWithOptions(token.VerifierOptions{
+		Region:                c.Region,
+		ClusterID:             c.ClusterID,
+		ClusterIDs:            clusterIDs,
+		Audiences:             audiences,
+		CacheSize:             c.TokenCacheSize,
+		CacheNegativeTTL:      c.TokenCacheNegativeTTL,
+		ClockSkew:             c.TokenClockSkew,
+		MaxConcurrentSTSCalls: c.MaxConcurrentSTSCalls,
+		STSEndpoints:          c.STSEndpoints,
+		STSMaxRetries:         c.STSMaxRetries,
+		STSRetryBackoff:       c.STSRetryBackoff,
+		STSBreakerFailures:    c.STSBreakerFailures,
+		STSBreakerCooldown:    c.STSBreakerCooldown,
+		NonceStore:            nonceStore,
+		NoncePolicy: token.NoncePolicy{
+			MaxReuses:   c.NonceMaxReuses,
+			ReuseWindow: c.NonceReuseWindow,
+		},
+	})
 
 	h := &handler{
-		verifier:         token.NewVerifier(c.Region, c.ClusterID),
-		clusterID:        c.ClusterID,
-		mappers:          mappers,
-		scrubbedAccounts: c.Config.ScrubbedAliyunAccounts,
+		verifier:      verifier,
+		clusterID:     c.ClusterID,
+		clusters:      clustersByID,
+		chainPolicy:   c.MapperChainPolicy,
+		explainGroups: c.ExplainAllowedGroups,
+		uidStrategy:   c.UIDStrategy,
+		uidTemplate:   c.UIDTemplate,
+		extraKeys:     c.ExtraKeys,
+		auditSink:     c.auditSink,
+		denyList:      c.denyList,
+	}
+	if c.ClientAddressRateLimitQPS > 0 {
+		limiter, err := httputil.NewKeyedRateLimiter(c.ClientAddressRateLimitQPS, c.ClientAddressRateLimitBurst, maxRateLimitedClients)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not create client address rate limiter")
+		}
+		h.clientLimiter = limiter
+	}
+	if c.AccessKeyRateLimitQPS > 0 {
+		limiter, err := httputil.NewKeyedRateLimiter(c.AccessKeyRateLimitQPS, c.AccessKeyRateLimitBurst, maxRateLimitedAccessKeys)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not create access key rate limiter")
+		}
+		h.accessKeyLimiter = limiter
+	}
+	if c.Region != "" {
+		resolver, err := ecs.NewResolver(ecs.Options{
+			Region:   c.Region,
+			Endpoint: c.ECSEndpoint,
+			CacheTTL: c.ECSInstanceCacheTTL,
+		})
+		if err != nil {
+			logrus.WithError(err).Fatal("could not create ECS instance resolver")
+		}
+		h.ecsResolver = resolver
+	} else {
+		logrus.Info("no region configured, the ECS template variables are not available")
 	}
 
 	h.HandleFunc("/authenticate", h.authenticateEndpoint)
+	if len(clusters) > 1 {
+		h.HandleFunc("/authenticate/", h.authenticateEndpoint)
+	}
+	if len(h.explainGroups) > 0 {
+		h.HandleFunc(ExplainPath, h.explainEndpoint)
+	}
 	h.Handle("/metrics", promhttp.Handler())
-	h.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "ok")
-	})
+	c.registerHealthChecks(&h.ServeMux)
 	return h
 }
 
@@ -229,8 +495,27 @@ func duration(start time.Time) float64 {
 	return time.Since(start).Seconds()
 }
 
+// clusterOf returns the cluster a verified identity was generated for. The
+// verifier only accepts tokens of the clusters served, other identities get
+// a cluster without mappers.
+func (h *handler) clusterOf(identity *token.Identity) *cluster {
+	if c, ok := h.clusters[identity.ClusterID]; ok {
+		return c
+	}
+	return &cluster{id: identity.ClusterID}
+}
+
+func hasBackendMode(modes []string, mode string) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *handler) isLoggableIdentity(identity *token.Identity) bool {
-	for _, account := range h.scrubbedAccounts {
+	for _, account := range h.clusterOf(identity).scrubbedAccounts {
 		if identity.AccountID == account {
 			return false
 		}
@@ -245,45 +530,63 @@ func (h *handler) authenticateEndpoint(w http.ResponseWriter, req *http.Request)
 		"client": req.RemoteAddr,
 		"method": req.Method,
 	})
+	event := &audit.Event{Time: start, Client: req.RemoteAddr}
+	defer h.audit(event)
+	// observe records the result of the request in the latency metric and
+	// the audit event.
+	observe := func(result string, reason string) {
+		metrics.Get().Latency.WithLabelValues(result).Observe(duration(start))
+		event.Result = result
+		event.Reason = reason
+	}
 
 	if req.Method != http.MethodPost {
 		log.Error("unexpected request method")
 		http.Error(w, "expected POST", http.StatusMethodNotAllowed)
-		metrics.Get().Latency.WithLabelValues(metrics.Malformed).Observe(duration(start))
+		observe(metrics.Malformed, "unexpected request method")
 		return
 	}
 	if req.Body == nil {
 		log.Error("empty request body")
 		http.Error(w, "expected a request body", http.StatusBadRequest)
-		metrics.Get().Latency.WithLabelValues(metrics.Malformed).Observe(duration(start))
+		observe(metrics.Malformed, "empty request body")
 		return
 	}
 	defer req.Body.Close()
 
-	var tokenReview authenticationv1beta1.TokenReview
-	if err := json.NewDecoder(req.Body).Decode(&tokenReview); err != nil {
+	tokenReview, err := decodeTokenReview(req.Body)
+	if err != nil {
 		log.WithError(err).Error("could not parse request body")
 		http.Error(w, "expected a request body to be a TokenReview", http.StatusBadRequest)
-		metrics.Get().Latency.WithLabelValues(metrics.Malformed).Observe(duration(start))
+		observe(metrics.Malformed, err.Error())
 		return
 	}
+	log = log.WithField("apiVersion", tokenReview.APIVersion)
+	event.TokenVersion = token.Version(tokenReview.Spec.Token)
 
-	// TODO: rate limit here
 	// all responses from here down have JSON bodies
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	// if the token is invalid, reject with a 403
-	identity, err := h.verifier.Verify(tokenReview.Spec.Token)
+	identity, result, err := h.verifyToken(tokenReview.Spec.Token, req.RemoteAddr)
 	if err != nil {
-		if _, ok := err.(token.STSError); ok {
-			metrics.Get().Latency.WithLabelValues(metrics.STSError).Observe(duration(start))
-		} else {
-			metrics.Get().Latency.WithLabelValues(metrics.Invalid).Observe(duration(start))
-		}
+		observe(result, err.Error())
+		log.WithError(err).Warn("access denied")
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
+		return
+	}
+
+	// a cluster's own route only accepts its tokens
+	if routed := strings.TrimPrefix(req.URL.Path, "/authenticate/"); routed != req.URL.Path && routed != identity.ClusterID {
+		err := token.NewFormatError(fmt.Sprintf("unexpected clusterid %s in token", identity.ClusterID))
+		observe(metrics.Invalid, err.Error())
 		log.WithError(err).Warn("access denied")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(newDenyTokenReview(err, tokenReview.TypeMeta))
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
 		return
 	}
+	if len(h.clusters) > 1 {
+		log = log.WithField("cluster", identity.ClusterID)
+		event.ClusterID = identity.ClusterID
+	}
 
 	if h.isLoggableIdentity(identity) {
 		log.WithFields(logrus.Fields{
@@ -296,96 +599,249 @@ func (h *handler) authenticateEndpoint(w http.ResponseWriter, req *http.Request)
 		// look up the ARN in each of our mappings to fill in the username and groups
 		log = log.WithField("arn", identity.CanonicalARN)
 	}
+	h.auditIdentity(event, identity)
+
+	if err := h.checkDenyList(identity); err != nil {
+		observe(metrics.Denied, err.Error())
+		log.WithError(err).Warn("access denied")
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
+		return
+	}
 
-	username, groups, err := h.doMapping(identity)
+	audiences, err := h.verifier.VerifyAudiences(identity, tokenReview.Spec.Audiences)
 	if err != nil {
-		metrics.Get().Latency.WithLabelValues(metrics.Unknown).Observe(duration(start))
+		observe(metrics.Invalid, err.Error())
 		log.WithError(err).Warn("access denied")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(newDenyTokenReview(NewMappingError(err), tokenReview.TypeMeta))
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
 		return
 	}
 
-	uid := fmt.Sprintf("ack-ram-authenticator:administrative:%s", username)
-	if h.isLoggableIdentity(identity) {
-		uid = fmt.Sprintf("ack-ram-authenticator:%s:%s", identity.AccountID, identity.UserID)
+	mapped, err := h.doMapping(identity)
+	if err != nil {
+		err = NewMappingError(err)
+		observe(metrics.Unknown, err.Error())
+		log.WithError(err).Warn("access denied")
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
+		return
+	}
+
+	user, err := h.userInfo(identity, mapped)
+	if err != nil {
+		err = NewMappingError(err)
+		observe(metrics.Unknown, err.Error())
+		log.WithError(err).Warn("access denied")
+		writeTokenReview(w, newDenyTokenReview(err, tokenReview.TypeMeta))
+		return
 	}
 
 	// the token is valid and the role is mapped, return success!
 	log.WithFields(logrus.Fields{
-		"username": username,
-		"uid":      uid,
-		"groups":   groups,
+		"username": user.Username,
+		"uid":      user.UID,
+		"groups":   user.Groups,
+		"backends": mapped.backends,
 	}).Info("access granted")
-	metrics.Get().Latency.WithLabelValues(metrics.Success).Observe(duration(start))
-	w.WriteHeader(http.StatusOK)
+	observe(metrics.Success, "")
+	event.Backends = mapped.backends
+	event.Username = user.Username
+	event.UID = user.UID
+	event.Groups = user.Groups
+	log.WithField("extra", user.Extra).Debug("user extra")
+
+	// audiences is empty when the cluster is audience agnostic, the apiserver
+	// then treats the review as valid for its own audiences.
+	writeTokenReview(w, &authenticationv1.TokenReview{
+		TypeMeta: tokenReview.TypeMeta,
+		Status: authenticationv1.TokenReviewStatus{
+			Authenticated: true,
+			Audiences:     audiences,
+			User:          user,
+		},
+	})
+}
 
-	userExtra := map[string]authenticationv1beta1.ExtraValue{}
-	if h.isLoggableIdentity(identity) {
-		log.Infof("begin to config user extra info")
-		userExtra["arn"] = authenticationv1beta1.ExtraValue{identity.ARN}
-		userExtra["canonicalArn"] = authenticationv1beta1.ExtraValue{identity.CanonicalARN}
-		userExtra["sessionName"] = authenticationv1beta1.ExtraValue{identity.SessionName}
+// verifyToken rate limits the client presenting a token, verifies the token
+// with replay protection and rate limits its verified AccessKeyID. On failure, it also returns the result to
+// record the failure with.
+func (h *handler) verifyToken(tok, remoteAddr string) (*token.Identity, string, error) {
+	if !h.allowClient(remoteAddr) {
+		return nil, metrics.Throttled, token.NewThrottledError("too many requests from this client")
+	}
+	identity, err := token.VerifyAndRecord(h.verifier, tok, clientHost(remoteAddr))
+	if err != nil {
+		return nil, verificationResult(err), err
+	}
+	if h.accessKeyLimiter != nil && !h.accessKeyLimiter.Allow(identity.AccessKeyID) {
+		logrus.WithField("accessKeyId", identity.AccessKeyID).Warn("access key exceeded rate limit")
+		return nil, metrics.Throttled, token.NewThrottledError("too many requests for the access key of this token")
 	}
+	return identity, metrics.Success, nil
+}
 
-	log.Infof("userExtra is %v", userExtra)
+// verificationResult returns the result to record a failed verification
+// with.
+func verificationResult(err error) string {
+	switch err.(type) {
+	case token.STSError:
+		return metrics.STSError
+	case token.ThrottledError:
+		return metrics.Throttled
+	case token.ReplayError:
+		return metrics.Replayed
+	default:
+		return metrics.Invalid
+	}
+}
 
-	json.NewEncoder(w).Encode(authenticationv1beta1.TokenReview{
-		Status: authenticationv1beta1.TokenReviewStatus{
-			Authenticated: true,
-			User: authenticationv1beta1.UserInfo{
-				Username: username,
-				UID:      uid,
-				Groups:   groups,
-				Extra:    userExtra,
-			},
-		},
-	})
+// checkDenyList returns a DeniedError if the deny list denies identity.
+func (h *handler) checkDenyList(identity *token.Identity) error {
+	if h.denyList == nil {
+		return nil
+	}
+	if rule, denied := h.denyList.Deny(identity); denied {
+		return NewDeniedError(rule)
+	}
+	return nil
 }
 
-func (h *handler) doMapping(identity *token.Identity) (string, []string, error) {
-	var errs []error
+// auditIdentity records identity in event unless its account is scrubbed.
+func (h *handler) auditIdentity(event *audit.Event, identity *token.Identity) {
+	if !h.isLoggableIdentity(identity) {
+		return
+	}
+	event.AccessKeyID = identity.AccessKeyID
+	event.AccountID = identity.AccountID
+	event.ARN = identity.ARN
+}
+
+// audit writes the event of a TokenReview to the audit sink, if any.
+func (h *handler) audit(event *audit.Event) {
+	if h.auditSink == nil {
+		return
+	}
+	event.Decision = audit.DecisionDeny
+	if event.Result == metrics.Success {
+		event.Decision = audit.DecisionAllow
+	}
+	h.auditSink.Write(event)
+}
+
+// mappingResult is the Kubernetes user an identity is mapped to, the
+// backends that mapped it and the names of their mappings.
+type mappingResult struct {
+	username string
+	groups   []string
+	backends []string
+	mappings []string
+}
+
+// doMapping maps an identity in two phases: first every backend is asked for
+// an explicit mapping of its ARN, and only if none has one, and none failed,
+// for an auto-mapping of its account.
+func (h *handler) doMapping(identity *token.Identity) (*mappingResult, error) {
+	return h.doTracedMapping(identity, nil)
+}
 
+// doTracedMapping is doMapping recording each lookup in trace, which may be nil.
+func (h *handler) doTracedMapping(identity *token.Identity, trace *mappingTrace) (*mappingResult, error) {
 	canonicalARN := strings.ToLower(identity.CanonicalARN)
+	result, err := h.mapChain(identity, phaseARN, trace, func(m mapper.Mapper) (*config.IdentityMapping, error) {
+		return m.Map(canonicalARN)
+	})
+	if err != mapper.ErrNotMapped {
+		trace.skip(phaseAccount, h.clusterOf(identity).mappers)
+		return result, err
+	}
+	return h.mapChain(identity, phaseAccount, trace, func(m mapper.Mapper) (*config.IdentityMapping, error) {
+		return m.MapAccount(identity.AccountID)
+	})
+}
 
-	for _, m := range h.mappers {
-		mapping, err := m.Map(canonicalARN)
-		if err == nil {
-			// Mapping found, try to render any templates like {{ECSPrivateDNSName}}
-			username, groups, err := h.renderTemplates(*mapping, identity)
-			if err != nil {
-				return "", nil, fmt.Errorf("mapper %s renderTemplates error: %v", m.Name(), err)
-			}
-			return username, groups, nil
-		} else {
+// mapChain looks up an identity in every backend according to the chain
+// policy. With ChainPolicyFirst the first backend that maps it wins. With
+// ChainPolicyMerge the username is rendered from the first backend that maps
+// it, in backend order, and the groups are the union of all of them in the
+// order they were first seen; backends that fail are then skipped. It returns
+// ErrNotMapped only if no backend maps the identity and none failed.
+func (h *handler) mapChain(identity *token.Identity, phase string, trace *mappingTrace, lookup func(mapper.Mapper) (*config.IdentityMapping, error)) (*mappingResult, error) {
+	var errs []error
+	var result *mappingResult
+	seen := map[string]bool{}
+
+	mappers := h.clusterOf(identity).mappers
+	for i, m := range mappers {
+		mapping, err := lookup(m)
+		if err != nil {
 			if err != mapper.ErrNotMapped {
 				errs = append(errs, fmt.Errorf("mapper %s Map error: %v", m.Name(), err))
 			}
-
-			if m.IsAccountAllowed(identity.AccountID) {
-				return identity.CanonicalARN, []string{}, nil
+			trace.add(phase, m.Name(), nil, err)
+			continue
+		}
+		// Mapping found, try to render any templates like {{SessionName}}
+		username, groups, err := h.renderTemplates(*mapping, identity)
+		if err != nil {
+			err = fmt.Errorf("mapper %s renderTemplates error: %v", m.Name(), err)
+			trace.add(phase, m.Name(), mapping, err)
+			trace.skip(phase, mappers[i+1:])
+			return nil, err
+		}
+		trace.add(phase, m.Name(), mapping, nil)
+		if h.chainPolicy != mapper.ChainPolicyMerge {
+			trace.skip(phase, mappers[i+1:])
+			return &mappingResult{username: username, groups: groups, backends: []string{m.Name()}, mappings: mappingNames(mapping)}, nil
+		}
+		if result == nil {
+			result = &mappingResult{username: username, groups: []string{}}
+		}
+		result.backends = append(result.backends, m.Name())
+		result.mappings = append(result.mappings, mappingNames(mapping)...)
+		for _, group := range groups {
+			if !seen[group] {
+				seen[group] = true
+				result.groups = append(result.groups, group)
 			}
 		}
 	}
 
+	if result != nil {
+		if len(errs) > 0 {
+			logrus.WithError(utilerrors.NewAggregate(errs)).Warn("merged mapping without the backends that failed")
+		}
+		return result, nil
+	}
 	if len(errs) > 0 {
-		return "", nil, utilerrors.NewAggregate(errs)
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return nil, mapper.ErrNotMapped
+}
+
+func mappingNames(mapping *config.IdentityMapping) []string {
+	if mapping.Name == "" {
+		return nil
 	}
-	return "", nil, mapper.ErrNotMapped
+	return []string{mapping.Name}
 }
+
 func (h *handler) renderTemplates(mapping config.IdentityMapping, identity *token.Identity) (string, []string, error) {
 	var username string
 	groups := []string{}
 	var err error
 
+	data := templateData(identity)
+	if referencesECS(mapping) {
+		if err := h.resolveECSInstance(identity, &data); err != nil {
+			return "", nil, err
+		}
+	}
 	userPattern := mapping.Username
-	username, err = h.renderTemplate(userPattern, identity)
+	username, err = template.Render(userPattern, data)
 	if err != nil {
 		return "", nil, fmt.Errorf("error rendering username template %q: %s", userPattern, err.Error())
 	}
 
 	for _, groupPattern := range mapping.Groups {
-		group, err := h.renderTemplate(groupPattern, identity)
+		group, err := template.Render(groupPattern, data)
 		if err != nil {
 			return "", nil, fmt.Errorf("error rendering group template %q: %s", groupPattern, err.Error())
 		}
@@ -395,20 +851,71 @@ func (h *handler) renderTemplates(mapping config.IdentityMapping, identity *toke
 	return username, groups, nil
 }
 
-func (h *handler) renderTemplate(template string, identity *token.Identity) (string, error) {
+// referencesECS reports whether any template of mapping uses an ECS variable.
+func referencesECS(mapping config.IdentityMapping) bool {
+	if template.ReferencesECS(mapping.Username) {
+		return true
+	}
+	for _, group := range mapping.Groups {
+		if template.ReferencesECS(group) {
+			return true
+		}
+	}
+	return false
+}
 
-	template = strings.Replace(template, "{{AccountID}}", identity.AccountID, -1)
-	sessionName := strings.Replace(identity.SessionName, "@", "-", -1)
-	template = strings.Replace(template, "{{SessionName}}", sessionName, -1)
-	template = strings.Replace(template, "{{SessionNameRaw}}", identity.SessionName, -1)
+// resolveECSInstance sets the ECS variables of data from the instance whose
+// ID is the session name. Templates using them are not rendered unless the
+// instance is found.
+func (h *handler) resolveECSInstance(identity *token.Identity, data *template.Data) error {
+	if h.ecsResolver == nil {
+		return fmt.Errorf("ECS template variables are used but no region is configured")
+	}
+	instanceID, ok := ecs.InstanceID(identity.SessionName)
+	if !ok {
+		return fmt.Errorf("ECS template variables are used but session name %q is not an ECS instance ID", identity.SessionName)
+	}
+	instance, err := h.ecsResolver.Resolve(instanceID)
+	if err != nil {
+		return fmt.Errorf("could not resolve ECS instance %s: %v", instanceID, err)
+	}
+	data.ECSPrivateDNSName = instance.PrivateDNSName
+	data.ECSHostname = instance.Hostname
+	data.ECSPrivateIP = instance.PrivateIP
+	return nil
+}
 
-	return template, nil
+// templateData returns the variables available to username and group templates.
+func templateData(identity *token.Identity) template.Data {
+	data := template.Data{
+		ARN:            identity.ARN,
+		CanonicalARN:   identity.CanonicalARN,
+		AccountID:      identity.AccountID,
+		UserID:         identity.UserID,
+		SessionName:    strings.Replace(identity.SessionName, "@", "-", -1),
+		SessionNameRaw: identity.SessionName,
+		AccessKeyID:    identity.AccessKeyID,
+		ClusterID:      identity.ClusterID,
+	}
+	// the canonical ARN keeps the case of the role or user name
+	if parsed, err := utils.Parse(identity.CanonicalARN); err == nil {
+		parts := strings.SplitN(parsed.Resource, "/", 2)
+		if len(parts) == 2 {
+			switch parts[0] {
+			case "role":
+				data.RoleName = parts[1]
+			case "user":
+				data.UserName = parts[1]
+			}
+		}
+	}
+	return data
 }
 
-func newDenyTokenReview(err error, meta metav1.TypeMeta) authenticationv1beta1.TokenReview {
-	tr := &authenticationv1beta1.TokenReview{
+func newDenyTokenReview(err error, meta metav1.TypeMeta) *authenticationv1.TokenReview {
+	tr := &authenticationv1.TokenReview{
 		TypeMeta: meta,
-		Status: authenticationv1beta1.TokenReviewStatus{
+		Status: authenticationv1.TokenReviewStatus{
 			Authenticated: false,
 		},
 	}
@@ -423,8 +930,17 @@ func newDenyTokenReview(err error, meta metav1.TypeMeta) authenticationv1beta1.T
 			if e.RaiseToUser() {
 				msg = e.RawMessage()
 			}
+		case token.AudienceError:
+			msg = err.Error()
+		case token.ThrottledError:
+			msg = err.Error()
+		case token.ReplayError:
+			msg = err.Error()
 		case MappingError:
 			msg = fmt.Sprintf("invalid token. %s", err.Error())
+		case DeniedError:
+			// the rule and its reason are only logged and audited
+			msg = "access denied"
 		}
 		if msg == "" {
 			msg = "invalid token"
@@ -433,5 +949,24 @@ func newDenyTokenReview(err error, meta metav1.TypeMeta) authenticationv1beta1.T
 	}
 
 	logrus.Warningf("deny error: %s", tr.Status.Error)
-	return *tr
+	return tr
+}
+
+// allowClient applies the client address rate limit to a request, before its
+// token is verified.
+func (h *handler) allowClient(remoteAddr string) bool {
+	if h.clientLimiter == nil {
+		return true
+	}
+	return h.clientLimiter.Allow(clientHost(remoteAddr))
+}
+
+// clientHost strips the port from a request's remote address so that all
+// connections from one client share a rate limit.
+func clientHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
 }
@@ -0,0 +1,635 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/audit"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/denylist"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/mapper"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token/ststest"
+)
+
+const (
+	testAccountID = "123456789012"
+	testUserARN   = "acs:ram::123456789012:user/alice"
+)
+
+func TestMain(m *testing.M) {
+	metrics.InitMetrics(prometheus.NewRegistry())
+	os.Exit(m.Run())
+}
+
+// staticMapper maps the canonical ARNs and accounts it was given.
+type staticMapper struct {
+	name     string
+	arns     map[string]*config.IdentityMapping
+	accounts map[string]*config.IdentityMapping
+	err      error
+	lookups  int
+}
+
+func (m *staticMapper) Name() string                       { return m.name }
+func (m *staticMapper) Start(stopCh <-chan struct{}) error { return nil }
+func (m *staticMapper) Ready() error                       { return nil }
+
+func (m *staticMapper) Map(canonicalARN string) (*config.IdentityMapping, error) {
+	return m.lookup(m.arns, canonicalARN)
+}
+
+func (m *staticMapper) MapAccount(accountID string) (*config.IdentityMapping, error) {
+	return m.lookup(m.accounts, accountID)
+}
+
+func (m *staticMapper) lookup(mappings map[string]*config.IdentityMapping, key string) (*config.IdentityMapping, error) {
+	m.lookups++
+	if m.err != nil {
+		return nil, m.err
+	}
+	if mapping, ok := mappings[key]; ok {
+		return mapping, nil
+	}
+	return nil, mapper.ErrNotMapped
+}
+
+// recordingSink keeps the audit events written to it.
+type recordingSink struct {
+	events []*audit.Event
+}
+
+func (s *recordingSink) Write(event *audit.Event) { s.events = append(s.events, event) }
+func (s *recordingSink) Close() error             { return nil }
+
+// userMapper maps testUserARN to username and groups.
+func userMapper(name, username string, groups ...string) *staticMapper {
+	return &staticMapper{name: name, arns: map[string]*config.IdentityMapping{
+		testUserARN: {Username: username, Groups: groups},
+	}}
+}
+
+// handlerTest is a handler verifying tokens against a local STS.
+type handlerTest struct {
+	sts     *ststest.Server
+	alice   ststest.Credential
+	handler *handler
+}
+
+// newHandlerTest serves clusters with the configuration of cfg, the first
+// cluster being the server's own.
+func newHandlerTest(t *testing.T, cfg config.Config, clusters ...*cluster) *handlerTest {
+	sts := ststest.NewServer()
+	t.Cleanup(sts.Close)
+
+	cfg.ClusterID = clusters[0].id
+	if cfg.UIDStrategy == "" {
+		cfg.UIDStrategy = UIDStrategyUserID
+	}
+	if cfg.ExtraKeys == nil {
+		cfg.ExtraKeys = DefaultExtraKeys
+	}
+	c := &Server{Config: cfg}
+	h := c.getHandler(clusters)
+
+	// the local STS is only trusted with its own root CAs
+	var clusterIDs []string
+	audiences := map[string][]string{}
+	for _, cl := range clusters[1:] {
+		clusterIDs = append(clusterIDs, cl.id)
+	}
+	for _, cl := range clusters {
+		audiences[cl.id] = cl.audiences
+	}
+	var nonceStore token.NonceStore
+	if cfg.NonceStoreSize > 0 {
+		nonceStore = token.NewLocalNonceStore(cfg.NonceStoreSize)
+	}
+	verifier := token.NewVerifierWithOptions(token.VerifierOptions{
+		ClusterID:   cfg.ClusterID,
+		ClusterIDs:  clusterIDs,
+		Audiences:   audiences,
+		CacheSize:   cfg.TokenCacheSize,
+		STSEndpoint: sts.Endpoint(),
+		RootCAs:     sts.RootCAs(),
+		NonceStore:  nonceStore,
+	})
+	h.verifier = verifier
+
+	return &handlerTest{
+		sts:     sts,
+		alice:   sts.AddUser(testAccountID, "alice", "300800000000000000"),
+		handler: h,
+	}
+}
+
+// newDenyList returns a deny list of the rules file content.
+func newDenyList(t *testing.T, content string) *denylist.List {
+	rules := filepath.Join(t.TempDir(), "deny.yaml")
+	if err := ioutil.WriteFile(rules, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := denylist.New(denylist.Options{File: rules})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	list.Start(stopCh)
+	return list
+}
+
+// token returns a new token of alice for clusterID.
+func (ht *handlerTest) token(clusterID string) string {
+	return ststest.V1Token(ht.alice, clusterID, time.Now())
+}
+
+func tokenReviewBody(apiVersion, tok string, audiences ...string) string {
+	review := authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: tok, Audiences: audiences}}
+	if apiVersion != "" {
+		review.APIVersion = apiVersion
+		review.Kind = tokenReviewKind
+	}
+	body, _ := json.Marshal(review)
+	return string(body)
+}
+
+// review posts body to path and returns the status code and the decoded
+// TokenReview of the response.
+func (ht *handlerTest) review(t *testing.T, path, body string) (int, *authenticationv1.TokenReview) {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:34567"
+	w := httptest.NewRecorder()
+	ht.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	var review authenticationv1.TokenReview
+	if err := json.NewDecoder(w.Body).Decode(&review); err != nil {
+		t.Fatalf("could not decode the response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, &review
+}
+
+// authenticate reviews tok on path in v1 and returns the status of the
+// review.
+func (ht *handlerTest) authenticate(t *testing.T, path, tok string, audiences ...string) authenticationv1.TokenReviewStatus {
+	code, review := ht.review(t, path, tokenReviewBody(tokenReviewV1, tok, audiences...))
+	if code != http.StatusOK {
+		t.Fatalf("expected the review to be answered but got %d", code)
+	}
+	return review.Status
+}
+
+func TestAuthenticateEndpointTokenReviewVersions(t *testing.T) {
+	ht := newHandlerTest(t, config.Config{}, &cluster{id: "cluster", mappers: []mapper.Mapper{userMapper("m", "alice")}})
+
+	for _, tc := range []struct {
+		name       string
+		body       string
+		code       int
+		apiVersion string
+	}{
+		{"v1", tokenReviewBody(tokenReviewV1, ht.token("cluster")), http.StatusOK, tokenReviewV1},
+		{"v1beta1", tokenReviewBody(tokenReviewV1beta1, ht.token("cluster")), http.StatusOK, tokenReviewV1beta1},
+		{"without TypeMeta", tokenReviewBody("", ht.token("cluster")), http.StatusOK, tokenReviewV1beta1},
+		{"unsupported version", tokenReviewBody("authentication.k8s.io/v2", ht.token("cluster")), http.StatusBadRequest, ""},
+		{"unexpected kind", `{"apiVersion":"authentication.k8s.io/v1","kind":"SubjectAccessReview"}`, http.StatusBadRequest, ""},
+		{"not JSON", `TokenReview`, http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, review := ht.review(t, "/authenticate", tc.body)
+			if code != tc.code {
+				t.Fatalf("expected status %d but got %d", tc.code, code)
+			}
+			if review == nil {
+				return
+			}
+			if review.APIVersion != tc.apiVersion || review.Kind != tokenReviewKind {
+				t.Errorf("expected a %s TokenReview but got %s %s", tc.apiVersion, review.APIVersion, review.Kind)
+			}
+			if !review.Status.Authenticated || review.Status.User.Username != "alice" {
+				t.Errorf("expected alice to be authenticated but got %+v", review.Status)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/authenticate", nil)
+	w := httptest.NewRecorder()
+	ht.handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected a GET to be rejected but got %d", w.Code)
+	}
+}
+
+func TestAuthenticateEndpointAudiences(t *testing.T) {
+	ht := newHandlerTest(t, config.Config{},
+		&cluster{id: "cluster", audiences: []string{"https://kubernetes.default.svc"}, mappers: []mapper.Mapper{userMapper("m", "alice")}},
+		&cluster{id: "agnostic", mappers: []mapper.Mapper{userMapper("m", "alice")}},
+	)
+
+	for _, tc := range []struct {
+		name          string
+		clusterID     string
+		requested     []string
+		authenticated bool
+		audiences     []string
+	}{
+		{"no audience requested", "cluster", nil, true, nil},
+		{"accepted audience", "cluster", []string{"vault", "https://kubernetes.default.svc"}, true, []string{"https://kubernetes.default.svc"}},
+		{"other audience", "cluster", []string{"vault"}, false, nil},
+		{"audience agnostic cluster", "agnostic", []string{"vault"}, true, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status := ht.authenticate(t, "/authenticate", ht.token(tc.clusterID), tc.requested...)
+			if status.Authenticated != tc.authenticated {
+				t.Fatalf("expected authenticated to be %v but got %+v", tc.authenticated, status)
+			}
+			if !reflect.DeepEqual(status.Audiences, tc.audiences) {
+				t.Errorf("expected the audiences %q but got %q", tc.audiences, status.Audiences)
+			}
+		})
+	}
+}
+
+func TestAuthenticateEndpointChainPolicies(t *testing.T) {
+	mappers := []mapper.Mapper{
+		&staticMapper{name: "failing", err: errors.New("unavailable")},
+		userMapper("first", "alice", "developers", "viewers"),
+		userMapper("second", "alice-admin", "viewers", "admins"),
+	}
+
+	for _, tc := range []struct {
+		policy   string
+		username string
+		groups   []string
+		mappedBy []string
+	}{
+		{mapper.ChainPolicyMerge, "alice", []string{"developers", "viewers", "admins"}, []string{"first", "second"}},
+		{mapper.ChainPolicyFirst, "alice", []string{"developers", "viewers"}, []string{"first"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			ht := newHandlerTest(t, config.Config{MapperChainPolicy: tc.policy, ExtraKeys: []string{ExtraMappedBy}}, &cluster{id: "cluster", mappers: mappers})
+			status := ht.authenticate(t, "/authenticate", ht.token("cluster"))
+			if !status.Authenticated {
+				t.Fatalf("expected the identity to be authenticated but got %q", status.Error)
+			}
+			if status.User.Username != tc.username || !reflect.DeepEqual(status.User.Groups, tc.groups) {
+				t.Errorf("expected %s in %q but got %s in %q", tc.username, tc.groups, status.User.Username, status.User.Groups)
+			}
+			if mappedBy := status.User.Extra[ExtraMappedBy]; !reflect.DeepEqual([]string(mappedBy), tc.mappedBy) {
+				t.Errorf("expected the identity to be mapped by %q but got %q", tc.mappedBy, mappedBy)
+			}
+		})
+	}
+}
+
+func TestAuthenticateEndpointMapsAccountsAfterARNs(t *testing.T) {
+	account := &staticMapper{name: "account", accounts: map[string]*config.IdentityMapping{
+		testAccountID: {Username: "{{CanonicalARN}}", Groups: []string{"auto"}},
+	}}
+
+	for _, tc := range []struct {
+		name     string
+		mappers  []mapper.Mapper
+		username string
+		groups   []string
+	}{
+		{"explicit mapping in a later backend", []mapper.Mapper{account, userMapper("arn", "alice", "developers")}, "alice", []string{"developers"}},
+		{"account mapping only", []mapper.Mapper{account, &staticMapper{name: "arn"}}, testUserARN, []string{"auto"}},
+		{"failing backend", []mapper.Mapper{account, &staticMapper{name: "failing", err: errors.New("unavailable")}}, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ht := newHandlerTest(t, config.Config{}, &cluster{id: "cluster", mappers: tc.mappers})
+			status := ht.authenticate(t, "/authenticate", ht.token("cluster"))
+			if tc.username == "" {
+				if status.Authenticated {
+					t.Errorf("expected the account mapping not to be used while a backend fails but got %+v", status.User)
+				}
+				return
+			}
+			if status.User.Username != tc.username || !reflect.DeepEqual(status.User.Groups, tc.groups) {
+				t.Errorf("expected %s in %q but got %+v %q", tc.username, tc.groups, status.User, status.Error)
+			}
+		})
+	}
+}
+
+func TestExplainEndpoint(t *testing.T) {
+	explain := func(ht *handlerTest, bearer string, request ExplainRequest) (int, *Explanation) {
+		body, _ := json.Marshal(request)
+		req := httptest.NewRequest(http.MethodPost, ExplainPath, strings.NewReader(string(body)))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		ht.handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var explanation Explanation
+		if err := json.NewDecoder(w.Body).Decode(&explanation); err != nil {
+			t.Fatalf("could not decode the response %q: %v", w.Body.String(), err)
+		}
+		return w.Code, &explanation
+	}
+	carol := "acs:ram::123456789012:user/carol"
+	m := &staticMapper{name: "m", arns: map[string]*config.IdentityMapping{
+		testUserARN: {Username: "alice", Groups: []string{"system:masters"}},
+		carol:       {Username: "carol"},
+	}}
+
+	ht := newHandlerTest(t, config.Config{}, &cluster{id: "cluster", mappers: []mapper.Mapper{m}})
+	if code, _ := explain(ht, ht.token("cluster"), ExplainRequest{ARN: carol}); code != http.StatusNotFound {
+		t.Errorf("expected the endpoint to be disabled without allowed groups but got %d", code)
+	}
+
+	ht = newHandlerTest(t, config.Config{ExplainAllowedGroups: []string{"system:masters"}}, &cluster{id: "cluster", mappers: []mapper.Mapper{m}})
+	if code, _ := explain(ht, "", ExplainRequest{ARN: carol}); code != http.StatusUnauthorized {
+		t.Errorf("expected a caller without token to be rejected but got %d", code)
+	}
+	carolToken := ststest.V1Token(ht.sts.AddUser(testAccountID, "carol", "300800000000000002"), "cluster", time.Now())
+	if code, _ := explain(ht, carolToken, ExplainRequest{ARN: carol}); code != http.StatusForbidden {
+		t.Errorf("expected a caller outside of the allowed groups to be forbidden but got %d", code)
+	}
+	code, explanation := explain(ht, ht.token("cluster"), ExplainRequest{ARN: carol})
+	if code != http.StatusOK {
+		t.Fatalf("expected alice to be allowed to explain but got %d", code)
+	}
+	steps := []MappingStep{
+		{Phase: phaseARN, Backend: "m", Result: StepHit, Username: "carol"},
+		{Phase: phaseAccount, Backend: "m", Result: StepSkipped},
+	}
+	if !explanation.Authenticated || explanation.User.Username != "carol" || !reflect.DeepEqual(explanation.Steps, steps) {
+		t.Errorf("expected carol to be explained by %+v but got %+v", steps, explanation)
+	}
+	if code, _ := explain(ht, ht.token("cluster"), ExplainRequest{ARN: carol, Token: carolToken}); code != http.StatusBadRequest {
+		t.Errorf("expected a request with both an ARN and a token to be rejected but got %d", code)
+	}
+
+	sink := &recordingSink{}
+	ht.handler.denyList = newDenyList(t, "deny:\n- arn: "+carol+"\n")
+	ht.handler.auditSink = sink
+	_, explanation = explain(ht, ht.token("cluster"), ExplainRequest{ARN: carol})
+	if explanation.Authenticated || !strings.HasPrefix(explanation.Error, "denied by rule") || explanation.Steps != nil || explanation.WildcardMatches != nil {
+		t.Errorf("expected a denied identity not to be explained further but got %+v", explanation)
+	}
+	event := sink.events[len(sink.events)-1]
+	if event.Path != ExplainPath || event.Result != metrics.Success || event.Username != "alice" {
+		t.Errorf("expected the caller to be audited but got %+v", event)
+	}
+
+	// explaining a token must not use it up
+	ht = newHandlerTest(t, config.Config{ExplainAllowedGroups: []string{"system:masters"}, TokenCacheSize: 10, NonceStoreSize: 10},
+		&cluster{id: "cluster", mappers: []mapper.Mapper{m}})
+	carolToken = ststest.V1Token(ht.sts.AddUser(testAccountID, "carol", "300800000000000002"), "cluster", time.Now())
+	if _, explanation := explain(ht, ht.token("cluster"), ExplainRequest{Token: carolToken}); !explanation.Authenticated {
+		t.Fatalf("expected carol's token to be explained but got %+v", explanation)
+	}
+	if status := ht.authenticate(t, "/authenticate", carolToken); !status.Authenticated || status.User.Username != "carol" {
+		t.Errorf("expected the explained token to be accepted but got %+v", status)
+	}
+	if status := ht.authenticate(t, "/authenticate", carolToken); status.Authenticated {
+		t.Errorf("expected the token to be replay protected once authenticated")
+	}
+}
+
+func TestAuthenticateEndpointDenyList(t *testing.T) {
+	m := &staticMapper{name: "m", arns: map[string]*config.IdentityMapping{
+		testUserARN:                      {Username: "alice", Groups: []string{"system:masters"}},
+		"acs:ram::123456789012:user/bob": {Username: "bob"},
+	}}
+	ht := newHandlerTest(t, config.Config{}, &cluster{id: "cluster", audiences: []string{"kubernetes"}, mappers: []mapper.Mapper{m}})
+	bob := ht.sts.AddUser(testAccountID, "bob", "300800000000000001")
+
+	sink := &recordingSink{}
+	ht.handler.denyList = newDenyList(t, "deny:\n- accessKeyID: "+ht.alice.AccessKeyID+"\n  reason: leaked\n")
+	ht.handler.auditSink = sink
+
+	for _, tc := range []struct {
+		name      string
+		tok       string
+		audiences []string
+		result    string
+	}{
+		// the deny list is checked before the audiences and the backends
+		{"denied", ht.token("cluster"), nil, metrics.Denied},
+		{"denied with another audience", ht.token("cluster"), []string{"vault"}, metrics.Denied},
+		{"other access key", ststest.V1Token(bob, "cluster", time.Now()), nil, metrics.Success},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m.lookups = 0
+			status := ht.authenticate(t, "/authenticate", tc.tok, tc.audiences...)
+			if status.Authenticated != (tc.result == metrics.Success) {
+				t.Fatalf("expected the result %s but got %+v", tc.result, status)
+			}
+			event := sink.events[len(sink.events)-1]
+			if event.Result != tc.result {
+				t.Errorf("expected the audited result %s but got %+v", tc.result, event)
+			}
+			if tc.result != metrics.Denied {
+				return
+			}
+			if status.Error != "[ack-ram-authenticator] access denied" || !strings.Contains(event.Reason, "leaked") {
+				t.Errorf("expected the reason to be audited but not returned, got %q and %q", status.Error, event.Reason)
+			}
+			if m.lookups != 0 {
+				t.Errorf("expected a denied identity not to be mapped but it was looked up %d times", m.lookups)
+			}
+		})
+	}
+}
+
+func TestAuthenticateEndpointClusterRoutes(t *testing.T) {
+	ht := newHandlerTest(t, config.Config{},
+		&cluster{id: "c1", mappers: []mapper.Mapper{userMapper("m", "alice-c1")}},
+		&cluster{id: "c2", mappers: []mapper.Mapper{userMapper("m", "alice-c2")}},
+	)
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		clusterID string
+		username  string
+	}{
+		{"shared route, own cluster", "/authenticate", "c1", "alice-c1"},
+		{"shared route, other cluster", "/authenticate", "c2", "alice-c2"},
+		{"cluster route", "/authenticate/c2", "c2", "alice-c2"},
+		{"route of another cluster", "/authenticate/c1", "c2", ""},
+		{"unknown route", "/authenticate/c3", "c1", ""},
+		{"token of an unknown cluster", "/authenticate", "c3", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status := ht.authenticate(t, tc.path, ht.token(tc.clusterID))
+			if status.Authenticated != (tc.username != "") || status.User.Username != tc.username {
+				t.Errorf("expected the user %q but got %+v", tc.username, status)
+			}
+		})
+	}
+}
+
+func TestAuthenticateEndpointUIDsAndExtras(t *testing.T) {
+	sum := sha256.Sum256([]byte(testUserARN))
+	for _, tc := range []struct {
+		name     string
+		cfg      config.Config
+		scrubbed bool
+		uid      string
+		extra    map[string]authenticationv1.ExtraValue
+	}{
+		{
+			name: "defaults",
+			uid:  "ack-ram-authenticator:123456789012:300800000000000000",
+			extra: map[string]authenticationv1.ExtraValue{
+				ExtraARN:          {testUserARN},
+				ExtraCanonicalARN: {testUserARN},
+				ExtraSessionName:  {""},
+			},
+		},
+		{
+			name: "canonical ARN hash",
+			cfg:  config.Config{UIDStrategy: UIDStrategyCanonicalARNHash, ExtraKeys: []string{ExtraAccountID, ExtraPrincipalType, ExtraClusterID}},
+			uid:  "ack-ram-authenticator:123456789012:" + hex.EncodeToString(sum[:]),
+			extra: map[string]authenticationv1.ExtraValue{
+				ExtraAccountID:     {testAccountID},
+				ExtraPrincipalType: {"user"},
+				ExtraClusterID:     {"cluster"},
+			},
+		},
+		{
+			name: "template",
+			cfg:  config.Config{UIDStrategy: UIDStrategyTemplate, UIDTemplate: "ram:{{AccountID}}:{{UserName}}", ExtraKeys: []string{ExtraMappedBy, ExtraMapping}},
+			uid:  "ram:123456789012:alice",
+			extra: map[string]authenticationv1.ExtraValue{
+				ExtraMappedBy: {"m"},
+				ExtraMapping:  {"developers"},
+			},
+		},
+		{
+			name:     "scrubbed account",
+			cfg:      config.Config{UIDStrategy: UIDStrategyCanonicalARNHash, ExtraKeys: []string{ExtraARN, ExtraAccessKeyID, ExtraPrincipalType}},
+			scrubbed: true,
+			uid:      "ack-ram-authenticator:administrative:alice",
+			extra: map[string]authenticationv1.ExtraValue{
+				ExtraPrincipalType: {"user"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &staticMapper{name: "m", arns: map[string]*config.IdentityMapping{
+				testUserARN: {Name: "developers", Username: "alice"},
+			}}
+			cl := &cluster{id: "cluster", mappers: []mapper.Mapper{m}}
+			if tc.scrubbed {
+				cl.scrubbedAccounts = []string{testAccountID}
+			}
+			ht := newHandlerTest(t, tc.cfg, cl)
+			status := ht.authenticate(t, "/authenticate", ht.token("cluster"))
+			if !status.Authenticated {
+				t.Fatalf("expected the identity to be authenticated but got %q", status.Error)
+			}
+			if status.User.UID != tc.uid {
+				t.Errorf("expected the UID %q but got %q", tc.uid, status.User.UID)
+			}
+			if !reflect.DeepEqual(status.User.Extra, tc.extra) {
+				t.Errorf("expected the extras %v but got %v", tc.extra, status.User.Extra)
+			}
+		})
+	}
+}
+
+func TestAuthenticateEndpointRateLimits(t *testing.T) {
+	ht := newHandlerTest(t, config.Config{AccessKeyRateLimitQPS: 0.001, AccessKeyRateLimitBurst: 1},
+		&cluster{id: "cluster", mappers: []mapper.Mapper{userMapper("m", "alice")}})
+
+	// a forged token claiming the AccessKeyID of alice must not use up her limit
+	forged := ststest.V1Token(ststest.Credential{AccessKeyID: ht.alice.AccessKeyID, AccessKeySecret: "forged"}, "cluster", time.Now())
+	if status := ht.authenticate(t, "/authenticate", forged); status.Authenticated {
+		t.Fatalf("expected the forged token to be rejected")
+	}
+	if status := ht.authenticate(t, "/authenticate", ht.token("cluster")); !status.Authenticated {
+		t.Fatalf("expected alice to be authenticated but got %q", status.Error)
+	}
+	if status := ht.authenticate(t, "/authenticate", ht.token("cluster")); status.Authenticated || !strings.Contains(status.Error, "too many requests") {
+		t.Errorf("expected alice to be throttled once her limit is used up but got %+v", status)
+	}
+
+	ht = newHandlerTest(t, config.Config{ClientAddressRateLimitQPS: 0.001, ClientAddressRateLimitBurst: 1},
+		&cluster{id: "cluster", mappers: []mapper.Mapper{userMapper("m", "alice")}})
+	if status := ht.authenticate(t, "/authenticate", "k8s-ack-v1.garbage"); status.Authenticated {
+		t.Fatalf("expected the invalid token to be rejected")
+	}
+	if status := ht.authenticate(t, "/authenticate", ht.token("cluster")); status.Authenticated || !strings.Contains(status.Error, "too many requests") {
+		t.Errorf("expected the client address to be throttled but got %+v", status)
+	}
+}
+
+func TestShutdownWaitsForInFlightRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Server{
+		Config:   config.Config{ShutdownGracePeriod: 10 * time.Millisecond},
+		listener: listener,
+		errLog:   ioutil.NopCloser(nil),
+		stopCh:   make(chan struct{}),
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	c.httpServer.Handler = c.trackInFlight(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}))
+	go c.httpServer.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		c.shutdown()
+		close(stopped)
+	}()
+	select {
+	case <-c.stopCh:
+		t.Fatalf("expected the mappers to be stopped only once the request returned")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected shutdown to return once the request returned")
+	}
+}
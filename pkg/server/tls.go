@@ -0,0 +1,81 @@
+/*
+Copyright 2017 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config/certs"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+// tlsConfig builds the TLS configuration of the webhook listener. When a
+// client CA is configured, clients must present a certificate signed by it
+// and, if AllowedClientNames is set, named after one of its patterns.
+func (c *Server) tlsConfig(certWatcher *certs.CertificateWatcher) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certWatcher.GetCertificate,
+	}
+
+	policy, err := certs.ParseTLSPolicy(c.TLSMinVersion, c.TLSMaxVersion, c.TLSCipherSuites, c.TLSCurvePreferences)
+	if err != nil {
+		return nil, err
+	}
+	policy.Apply(tlsConfig)
+	minVersion := policy.MinVersionName()
+	if policy.MinVersion == 0 {
+		minVersion = "1.2"
+	}
+	logrus.WithFields(logrus.Fields{
+		"minVersion":   minVersion,
+		"maxVersion":   policy.MaxVersionName(),
+		"cipherSuites": policy.CipherSuiteNames(),
+		"curves":       policy.CurveNames(),
+	}).Info("tls policy")
+	metrics.Get().TLSPolicyInfo.WithLabelValues(minVersion, policy.MaxVersionName(), policy.CipherSuiteNames(), policy.CurveNames()).Set(1)
+
+	if c.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	clientCAs, err := certs.LoadCertPool(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load client CA: %v", err)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	logrus.WithField("clientCAFile", c.ClientCAFile).Info("requiring client certificates")
+
+	if len(c.AllowedClientNames) > 0 {
+		allowed := c.AllowedClientNames
+		tlsConfig.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				if len(chain) > 0 && certs.MatchesNamePatterns(chain[0], allowed) {
+					return nil
+				}
+			}
+			logrus.Warn("rejected client certificate with a name that is not allowed")
+			return fmt.Errorf("client certificate name is not allowed")
+		}
+		logrus.WithField("allowedClientNames", allowed).Info("restricting client certificate names")
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authenticationv1beta1 "k8s.io/api/authentication/v1beta1"
+)
+
+const tokenReviewKind = "TokenReview"
+
+var (
+	tokenReviewV1      = authenticationv1.SchemeGroupVersion.String()
+	tokenReviewV1beta1 = authenticationv1beta1.SchemeGroupVersion.String()
+)
+
+// decodeTokenReview reads an authentication.k8s.io/v1 or v1beta1 TokenReview.
+// Both versions share the same wire format, so the review is always decoded
+// into the v1 type; the apiVersion sent by the apiserver is kept in TypeMeta
+// so that the response can be written back in the same version.
+func decodeTokenReview(r io.Reader) (*authenticationv1.TokenReview, error) {
+	var review authenticationv1.TokenReview
+	if err := json.NewDecoder(r).Decode(&review); err != nil {
+		return nil, err
+	}
+
+	switch review.APIVersion {
+	case tokenReviewV1, tokenReviewV1beta1:
+	case "":
+		// apiservers predating v1 may omit TypeMeta, keep answering them in v1beta1
+		review.APIVersion = tokenReviewV1beta1
+	default:
+		return nil, fmt.Errorf("unsupported TokenReview apiVersion %q", review.APIVersion)
+	}
+	if review.Kind != "" && review.Kind != tokenReviewKind {
+		return nil, fmt.Errorf("unexpected kind %q, expected %q", review.Kind, tokenReviewKind)
+	}
+	review.Kind = tokenReviewKind
+
+	return &review, nil
+}
+
+// writeTokenReview encodes the review using the apiVersion carried in its TypeMeta.
+func writeTokenReview(w http.ResponseWriter, review *authenticationv1.TokenReview) error {
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(review)
+}
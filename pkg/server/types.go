@@ -17,16 +17,38 @@ limitations under the License.
 package server
 
 import (
+	"io"
 	"net"
 	"net/http"
+	"sync"
 
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/audit"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/config"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/denylist"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
 )
 
 // Server for the authentication webhook.
 type Server struct {
 	// Config is the whole configuration of ack-ram-authenticator used for valid keys and certs, kubeconfig, and so on
 	config.Config
-	httpServer http.Server
-	listener   net.Listener
+	httpServer   http.Server
+	healthServer *http.Server
+	listener     net.Listener
+	// healthListener is nil when the health server is disabled
+	healthListener net.Listener
+	errLog         io.Closer
+	clusters       []*cluster
+	// auditSink is nil when no audit sink is configured
+	auditSink audit.Sink
+	// denyList is nil when no deny list is configured
+	denyList *denylist.List
+	// nonceStore is nil unless passed in the Options
+	nonceStore token.NonceStore
+	// stopCh stops the mappers and watchers once in-flight requests are drained
+	stopCh chan struct{}
+	// draining is set to 1 when shutdown starts and fails the health checks
+	draining int32
+	// inFlight counts the requests being handled by the webhook
+	inFlight sync.WaitGroup
 }
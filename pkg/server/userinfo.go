@@ -0,0 +1,191 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/template"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+)
+
+const (
+	// UIDStrategyUserID makes the UID "ack-ram-authenticator:<account>:<user ID>",
+	// which changes when a role or user is recreated.
+	UIDStrategyUserID = "userid"
+	// UIDStrategyCanonicalARNHash makes the UID
+	// "ack-ram-authenticator:<account>:<SHA-256 of the canonical ARN>", which
+	// is the same for every session of a role.
+	UIDStrategyCanonicalARNHash = "canonical-arn-hash"
+	// UIDStrategyTemplate renders the UID from a template like the username
+	// templates.
+	UIDStrategyTemplate = "template"
+)
+
+var UIDStrategyChoices = []string{UIDStrategyUserID, UIDStrategyCanonicalARNHash, UIDStrategyTemplate}
+
+// Keys of UserInfo.Extra.
+const (
+	ExtraARN           = "arn"
+	ExtraCanonicalARN  = "canonicalArn"
+	ExtraSessionName   = "sessionName"
+	ExtraAccessKeyID   = "accessKeyId"
+	ExtraAccountID     = "accountId"
+	ExtraPrincipalType = "principalType"
+	// ExtraMappedBy are the backends that mapped the identity.
+	ExtraMappedBy = "mappedBy"
+	// ExtraMapping are the names of the mappings that matched, see
+	// config.IdentityMapping.
+	ExtraMapping   = "mapping"
+	ExtraClusterID = "clusterId"
+)
+
+var ExtraKeyChoices = []string{ExtraARN, ExtraCanonicalARN, ExtraSessionName, ExtraAccessKeyID, ExtraAccountID, ExtraPrincipalType, ExtraMappedBy, ExtraMapping, ExtraClusterID}
+
+// DefaultExtraKeys are the extras returned when none are configured.
+var DefaultExtraKeys = []string{ExtraARN, ExtraCanonicalARN, ExtraSessionName}
+
+// identifyingExtraKeys are left out for scrubbed accounts.
+var identifyingExtraKeys = sets.NewString(ExtraARN, ExtraCanonicalARN, ExtraSessionName, ExtraAccessKeyID, ExtraAccountID)
+
+func ValidateUIDStrategy(strategy, uidTemplate string) error {
+	switch strategy {
+	case UIDStrategyUserID, UIDStrategyCanonicalARNHash:
+		return nil
+	case UIDStrategyTemplate:
+		if uidTemplate == "" {
+			return fmt.Errorf("uid-strategy %q requires a uid-template", strategy)
+		}
+		if err := template.Validate(uidTemplate); err != nil {
+			return fmt.Errorf("invalid uid-template: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("uid-strategy %q is not one of %q", strategy, UIDStrategyChoices)
+}
+
+func ValidateExtraKeys(keys []string) error {
+	valid := sets.NewString(ExtraKeyChoices...)
+	for _, key := range keys {
+		if !valid.Has(key) {
+			return fmt.Errorf("extra key %q is not one of %q", key, ExtraKeyChoices)
+		}
+	}
+	return nil
+}
+
+// userInfo returns the user a mapped identity authenticates as. Identities of
+// scrubbed accounts get a UID derived from their username and no
+// identifying extras, whatever the configuration.
+func (h *handler) userInfo(identity *token.Identity, mapped *mappingResult) (authenticationv1.UserInfo, error) {
+	loggable := h.isLoggableIdentity(identity)
+	uid := fmt.Sprintf("ack-ram-authenticator:administrative:%s", mapped.username)
+	if loggable {
+		var err error
+		if uid, err = h.uid(identity); err != nil {
+			return authenticationv1.UserInfo{}, err
+		}
+	}
+
+	userExtra := map[string]authenticationv1.ExtraValue{}
+	for _, key := range h.extraKeys {
+		if !loggable && identifyingExtraKeys.Has(key) {
+			continue
+		}
+		if value := extraValue(key, identity, mapped); len(value) > 0 {
+			userExtra[key] = value
+		}
+	}
+
+	return authenticationv1.UserInfo{
+		Username: mapped.username,
+		UID:      uid,
+		Groups:   mapped.groups,
+		Extra:    userExtra,
+	}, nil
+}
+
+func (h *handler) uid(identity *token.Identity) (string, error) {
+	switch h.uidStrategy {
+	case UIDStrategyCanonicalARNHash:
+		sum := sha256.Sum256([]byte(strings.ToLower(identity.CanonicalARN)))
+		return fmt.Sprintf("ack-ram-authenticator:%s:%s", identity.AccountID, hex.EncodeToString(sum[:])), nil
+	case UIDStrategyTemplate:
+		data := templateData(identity)
+		if template.ReferencesECS(h.uidTemplate) {
+			if err := h.resolveECSInstance(identity, &data); err != nil {
+				return "", err
+			}
+		}
+		uid, err := template.Render(h.uidTemplate, data)
+		if err != nil {
+			return "", fmt.Errorf("error rendering uid template %q: %v", h.uidTemplate, err)
+		}
+		if uid == "" {
+			return "", fmt.Errorf("uid template %q rendered an empty uid", h.uidTemplate)
+		}
+		return uid, nil
+	}
+	return fmt.Sprintf("ack-ram-authenticator:%s:%s", identity.AccountID, identity.UserID), nil
+}
+
+func extraValue(key string, identity *token.Identity, mapped *mappingResult) authenticationv1.ExtraValue {
+	var value string
+	switch key {
+	case ExtraARN:
+		value = identity.ARN
+	case ExtraCanonicalARN:
+		value = identity.CanonicalARN
+	case ExtraSessionName:
+		value = identity.SessionName
+	case ExtraAccessKeyID:
+		value = identity.AccessKeyID
+	case ExtraAccountID:
+		value = identity.AccountID
+	case ExtraPrincipalType:
+		value = principalType(identity.CanonicalARN)
+	case ExtraMappedBy:
+		return authenticationv1.ExtraValue(mapped.backends)
+	case ExtraMapping:
+		return authenticationv1.ExtraValue(mapped.mappings)
+	case ExtraClusterID:
+		value = identity.ClusterID
+	}
+	// The default extras were always returned, even when empty (there is no
+	// session name for a RAM user), the others are left out when empty.
+	if value == "" && !sets.NewString(DefaultExtraKeys...).Has(key) {
+		return nil
+	}
+	return authenticationv1.ExtraValue{value}
+}
+
+// principalType returns the resource type of a canonical ARN: "role", "user"
+// or "root".
+func principalType(canonicalARN string) string {
+	parsed, err := utils.Parse(canonicalARN)
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(parsed.Resource, "/", 2)[0]
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package template renders the username and group templates of identity
+// mappings with text/template.
+//
+// Every field of Data can be used either as a field, "{{.AccountID}}", or as
+// a function, "{{AccountID}}", the syntax of the original string replacement
+// templates. Only the functions in this package are available, and unknown
+// variables or functions are errors, so a mapping never silently renders a
+// placeholder into a username or group.
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+)
+
+// Data is the identity a template is rendered for.
+type Data struct {
+	// ARN is the raw ARN returned by STS.
+	ARN string
+	// CanonicalARN is the role or user ARN the identity was mapped by.
+	CanonicalARN string
+	// AccountID is the 16 digit RAM account ID.
+	AccountID string
+	// UserID is the unique user or role ID.
+	UserID string
+	// SessionName is the STS session name with "@" replaced by "-".
+	SessionName string
+	// SessionNameRaw is the STS session name as returned by STS.
+	SessionNameRaw string
+	// AccessKeyID is the access key that signed the token.
+	AccessKeyID string
+	// ClusterID is the cluster the token was generated for.
+	ClusterID string
+	// RoleName is the name of the RAM role, empty for users.
+	RoleName string
+	// UserName is the name of the RAM user, empty for roles.
+	UserName string
+	// ECSPrivateDNSName is the node name of the ECS instance whose ID is the
+	// session name, "<region>.<private IP>".
+	ECSPrivateDNSName string
+	// ECSHostname is the hostname of the ECS instance.
+	ECSHostname string
+	// ECSPrivateIP is the primary private IP of the ECS instance.
+	ECSPrivateIP string
+}
+
+// ecsVariablePrefix prefixes the variables that are resolved with the ECS API.
+const ecsVariablePrefix = "ECS"
+
+// variables are the names of the Data fields, which are also registered as
+// functions returning the field.
+var variables = func() []string {
+	var names []string
+	t := reflect.TypeOf(Data{})
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, t.Field(i).Name)
+	}
+	return names
+}()
+
+// maxCachedTemplates bounds the parsed templates and compiled regular
+// expressions kept in memory. Mappings are reloaded when they are edited, so
+// the texts rendered over the life of the process are unbounded.
+const maxCachedTemplates = 1024
+
+// cache is a utils.LRU safe for concurrent use.
+type cache struct {
+	mutex   sync.Mutex
+	entries *utils.LRU
+}
+
+func newCache() *cache {
+	return &cache{entries: utils.NewLRU(maxCachedTemplates)}
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.entries.Get(key)
+}
+
+func (c *cache) add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries.Add(key, value)
+}
+
+// parsed caches parsed templates by their text.
+var parsed = newCache()
+
+// Validate parses text and renders it for an empty identity, returning an
+// error for syntax errors and unknown variables or functions.
+func Validate(text string) error {
+	_, err := Render(text, Data{})
+	return err
+}
+
+// Render renders text for the identity in data.
+func Render(text string, data Data) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	base, err := parseText(text)
+	if err != nil {
+		return "", err
+	}
+
+	// bind the variable functions to this identity on a copy of the
+	// parsed template
+	t, err := base.Clone()
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(variableFuncs(data))
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("template %q: %v", text, err)
+	}
+	return out.String(), nil
+}
+
+// ReferencesECS reports whether text uses an ECS variable. The ECS variables
+// are only resolved for templates that use them.
+func ReferencesECS(text string) bool {
+	if !strings.Contains(text, ecsVariablePrefix) {
+		return false
+	}
+	t, err := parseText(text)
+	if err != nil {
+		return false
+	}
+	return referencesECS(t.Tree.Root)
+}
+
+func referencesECS(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if referencesECS(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return referencesECS(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if referencesECS(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if referencesECS(arg) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return referencesECS(n.Pipe) || referencesECS(n.List) || referencesECS(n.ElseList)
+	case *parse.RangeNode:
+		return referencesECS(n.Pipe) || referencesECS(n.List) || referencesECS(n.ElseList)
+	case *parse.WithNode:
+		return referencesECS(n.Pipe) || referencesECS(n.List) || referencesECS(n.ElseList)
+	case *parse.IdentifierNode:
+		return strings.HasPrefix(n.Ident, ecsVariablePrefix)
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && strings.HasPrefix(n.Ident[0], ecsVariablePrefix)
+	}
+	return false
+}
+
+func parseText(text string) (*template.Template, error) {
+	if t, ok := parsed.get(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("mapping").
+		Option("missingkey=error").
+		Funcs(variableFuncs(Data{})).
+		Funcs(funcs).
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	parsed.add(text, t)
+	return t, nil
+}
+
+func variableFuncs(data Data) template.FuncMap {
+	v := reflect.ValueOf(data)
+	m := template.FuncMap{}
+	for _, name := range variables {
+		value := v.FieldByName(name).String()
+		m[name] = func() string { return value }
+	}
+	return m
+}
+
+// funcs are the functions available to templates besides the variables.
+var funcs = template.FuncMap{
+	"lower":        strings.ToLower,
+	"trimPrefix":   trimPrefix,
+	"regexReplace": regexReplace,
+	"truncate":     truncate,
+	"dns1123":      dns1123,
+}
+
+// trimPrefix takes the prefix first so that it can be used in pipelines:
+// {{SessionName | trimPrefix "dev-"}}
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+// regexps caches the compiled patterns of regexReplace.
+var regexps = newCache()
+
+// regexReplace replaces the matches of pattern in s with replacement, which
+// can refer to submatches as $1.
+func regexReplace(pattern, replacement, s string) (string, error) {
+	var re *regexp.Regexp
+	if cached, ok := regexps.get(pattern); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		regexps.add(pattern, compiled)
+		re = compiled
+	}
+	return re.ReplaceAllString(s, replacement), nil
+}
+
+// truncate returns the first n characters of s.
+func truncate(n int, s string) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("truncate length %d is negative", n)
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s, nil
+	}
+	return string(runes[:n]), nil
+}
+
+var dns1123Invalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dns1123 converts s to a valid DNS-1123 label: lower case alphanumerics and
+// "-", starting and ending with an alphanumeric, at most 63 characters.
+func dns1123(s string) string {
+	s = dns1123Invalid.ReplaceAllString(strings.ToLower(s), "-")
+	s = strings.Trim(s, "-")
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package template
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var data = Data{
+	ARN:            "acs:ram::123456789012:assumed-role/Developers/alice@example.com",
+	CanonicalARN:   "acs:ram::123456789012:role/developers",
+	AccountID:      "123456789012",
+	UserID:         "300800000000000",
+	SessionName:    "alice-example.com",
+	SessionNameRaw: "alice@example.com",
+	AccessKeyID:    "STS.example",
+	ClusterID:      "c1",
+	RoleName:       "Developers",
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"kubernetes-admin", "kubernetes-admin"},
+		{"ram:{{AccountID}}:{{SessionName}}", "ram:123456789012:alice-example.com"},
+		{"{{SessionNameRaw}}", "alice@example.com"},
+		{"{{.RoleName}}:{{.AccessKeyID}}", "Developers:STS.example"},
+		{"{{RoleName | lower}}", "developers"},
+		{`{{SessionName | trimPrefix "alice-"}}`, "example.com"},
+		{`{{regexReplace "@.*$" "" SessionNameRaw}}`, "alice"},
+		{"{{SessionName | truncate 5}}", "alice"},
+		{"{{SessionNameRaw | dns1123}}", "alice-example-com"},
+		{"{{ClusterID}}-{{UserName}}", "c1-"},
+	}
+	for _, test := range tests {
+		rendered, err := Render(test.template, data)
+		if err != nil {
+			t.Errorf("%s: expected error to be nil was %q", test.template, err)
+			continue
+		}
+		if rendered != test.expected {
+			t.Errorf("%s: expected %q but was %q", test.template, test.expected, rendered)
+		}
+	}
+}
+
+func TestRenderFailsClosed(t *testing.T) {
+	for _, text := range []string{
+		"{{InstanceID}}",
+		"{{.Unknown}}",
+		"{{exec}}",
+		"{{AccountID",
+		`{{regexReplace "(" "" SessionName}}`,
+		"{{SessionName | truncate -1}}",
+	} {
+		if _, err := Render(text, data); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+		if err := Validate(text); err == nil {
+			t.Errorf("%s: expected validation to fail", text)
+		}
+	}
+}
+
+func TestRenderDoesNotShareVariables(t *testing.T) {
+	other := data
+	other.AccountID = "999999999999"
+	first, _ := Render("{{AccountID}}", data)
+	second, _ := Render("{{AccountID}}", other)
+	if first == second {
+		t.Errorf("expected each render to use its own identity, got %q twice", first)
+	}
+}
+
+func TestReferencesECS(t *testing.T) {
+	tests := map[string]bool{
+		"system:node:{{ECSPrivateDNSName}}":         true,
+		"{{.ECSHostname | lower}}":                  true,
+		`{{if SessionName}}{{ECSPrivateIP}}{{end}}`: true,
+		"ECS-{{SessionName}}":                       false,
+		"ecs-nodes":                                 false,
+		"{{ECSPrivateIP":                            false,
+	}
+	for text, expected := range tests {
+		if ReferencesECS(text) != expected {
+			t.Errorf("%s: expected ReferencesECS to be %v", text, expected)
+		}
+	}
+}
+
+func TestDNS1123(t *testing.T) {
+	long := strings.Repeat("a", 62) + "-b"
+	tests := map[string]string{
+		"Alice@Example.com": "alice-example-com",
+		"--x--":             "x",
+		long:                strings.Repeat("a", 62),
+	}
+	for in, expected := range tests {
+		if out := dns1123(in); out != expected {
+			t.Errorf("dns1123(%q): expected %q but was %q", in, expected, out)
+		}
+	}
+}
+
+func TestCachesAreBounded(t *testing.T) {
+	for i := 0; i < maxCachedTemplates+10; i++ {
+		text := fmt.Sprintf(`{{SessionName | regexReplace "^%d-" ""}}`, i)
+		if _, err := Render(text, Data{SessionName: "0-alice"}); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+	}
+	if parsed.entries.Len() > maxCachedTemplates || regexps.entries.Len() > maxCachedTemplates {
+		t.Errorf("expected at most %d cached templates and regexps but got %d and %d", maxCachedTemplates, parsed.entries.Len(), regexps.entries.Len())
+	}
+}
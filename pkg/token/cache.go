@@ -0,0 +1,146 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
+)
+
+// identityCache is a bounded LRU cache of verification results keyed by a
+// hash of the token. Successful results are kept until the presigned request
+// expires, definitive STS denials for a short negative TTL.
+type identityCache struct {
+	mutex       sync.Mutex
+	entries     *utils.LRU
+	negativeTTL time.Duration
+	now         func() time.Time
+}
+
+type identityCacheEntry struct {
+	identity  *Identity
+	err       error
+	expiresAt time.Time
+}
+
+func newIdentityCache(size int, negativeTTL time.Duration) *identityCache {
+	c := &identityCache{
+		entries:     utils.NewLRU(size),
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+	}
+	c.entries.OnEvicted = func(_ string, value interface{}) {
+		reason := "capacity"
+		if !c.now().Before(value.(*identityCacheEntry).expiresAt) {
+			reason = "expired"
+		}
+		if metrics.Initialized() {
+			metrics.Get().TokenCacheEvictions.WithLabelValues(reason).Inc()
+		}
+	}
+	return c
+}
+
+func (c *identityCache) get(key string) (*identityCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	value, ok := c.entries.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(*identityCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.entries.Remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *identityCache) add(key string, entry *identityCacheEntry) {
+	if !c.now().Before(entry.expiresAt) {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries.Add(key, entry)
+}
+
+// cachingVerifier serves repeated verifications of the same token from an
+// identityCache instead of calling STS again.
+type cachingVerifier struct {
+	Verifier
+	cache *identityCache
+}
+
+func newCachingVerifier(verifier Verifier, size int, negativeTTL time.Duration) *cachingVerifier {
+	return &cachingVerifier{
+		Verifier: verifier,
+		cache:    newIdentityCache(size, negativeTTL),
+	}
+}
+
+func (v *cachingVerifier) Verify(token string) (*Identity, error) {
+	key := hashToken(token)
+	if entry, ok := v.cache.get(key); ok {
+		if entry.err != nil {
+			observeTokenCache(metrics.CacheNegativeHit)
+			return nil, entry.err
+		}
+		observeTokenCache(metrics.CacheHit)
+		identity := *entry.identity
+		return &identity, nil
+	}
+	observeTokenCache(metrics.CacheMiss)
+
+	identity, err := v.Verifier.Verify(token)
+	if err != nil {
+		if stsErr, ok := err.(STSError); ok && stsErr.Definitive() && v.cache.negativeTTL > 0 {
+			v.cache.add(key, &identityCacheEntry{
+				err:       err,
+				expiresAt: v.cache.now().Add(v.cache.negativeTTL),
+			})
+		}
+		return nil, err
+	}
+
+	// tokens without a readable signing time are not cached
+	if info, err := inspectToken(token); err == nil {
+		cached := *identity
+		v.cache.add(key, &identityCacheEntry{
+			identity:  &cached,
+			expiresAt: info.expiresAt(),
+		})
+	}
+	return identity, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func observeTokenCache(result string) {
+	if metrics.Initialized() {
+		metrics.Get().TokenCacheRequests.WithLabelValues(result).Inc()
+	}
+}
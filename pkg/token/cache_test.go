@@ -0,0 +1,109 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingVerifier struct {
+	Verifier
+	calls    int
+	identity *Identity
+	err      error
+}
+
+func (v *countingVerifier) Verify(token string) (*Identity, error) {
+	v.calls++
+	if v.err != nil {
+		return nil, v.err
+	}
+	identity := *v.identity
+	return &identity, nil
+}
+
+func TestCachingVerifierCachesIdentityUntilExpiration(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{ARN: "acs:ram::123456789012:user/Alice"}}
+	v := newCachingVerifier(inner, 10, time.Second)
+
+	for i := 0; i < 3; i++ {
+		identity, err := v.Verify(validToken)
+		if err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+		if identity.ARN != inner.identity.ARN {
+			t.Errorf("expected ARN to be %q but was %q", inner.identity.ARN, identity.ARN)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected 1 STS call but got %d", inner.calls)
+	}
+
+	v.cache.now = func() time.Time { return now.Add(presignedURLExpiration + time.Second) }
+	if _, err := v.Verify(validToken); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected expired token to be verified again, got %d STS calls", inner.calls)
+	}
+}
+
+func TestCachingVerifierNegativeCache(t *testing.T) {
+	inner := &countingVerifier{err: newOpenAPIErr(403, []byte(`{"Code":"InvalidAccessKeyId.NotFound"}`), nil)}
+	v := newCachingVerifier(inner, 10, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := v.Verify(validToken)
+		assertSTSError(t, err)
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected definitive denial to be cached, got %d STS calls", inner.calls)
+	}
+
+	inner = &countingVerifier{err: newOpenAPIErr(403, []byte(`{"Code":"Throttling.User"}`), nil)}
+	v = newCachingVerifier(inner, 10, time.Minute)
+	for i := 0; i < 2; i++ {
+		v.Verify(validToken)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected throttling not to be cached, got %d STS calls", inner.calls)
+	}
+
+	inner = &countingVerifier{err: newOpenAPIErr(400, nil, errors.New("connection reset"))}
+	v = newCachingVerifier(inner, 10, time.Minute)
+	for i := 0; i < 2; i++ {
+		v.Verify(validToken)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected transport errors not to be cached, got %d STS calls", inner.calls)
+	}
+}
+
+func TestCachingVerifierEvictsLeastRecentlyUsed(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{}}
+	v := newCachingVerifier(inner, 1, 0)
+
+	other := toToken(v1URL(map[string]string{"SignatureNonce": "other"}))
+	v.Verify(validToken)
+	v.Verify(other)
+	v.Verify(validToken)
+	if inner.calls != 3 {
+		t.Errorf("expected evicted token to be verified again, got %d STS calls", inner.calls)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"sync"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+// coalescingVerifier lets concurrent verifications of the same token share a
+// single in-flight STS call and its result.
+type coalescingVerifier struct {
+	Verifier
+	mutex sync.Mutex
+	calls map[string]*verifyCall
+}
+
+type verifyCall struct {
+	done     chan struct{}
+	waiters  int
+	identity *Identity
+	err      error
+}
+
+func newCoalescingVerifier(verifier Verifier) *coalescingVerifier {
+	return &coalescingVerifier{
+		Verifier: verifier,
+		calls:    make(map[string]*verifyCall),
+	}
+}
+
+func (v *coalescingVerifier) Verify(token string) (*Identity, error) {
+	key := hashToken(token)
+
+	v.mutex.Lock()
+	call, inFlight := v.calls[key]
+	if inFlight {
+		call.waiters++
+	} else {
+		call = &verifyCall{done: make(chan struct{})}
+		v.calls[key] = call
+	}
+	v.mutex.Unlock()
+
+	if inFlight {
+		if metrics.Initialized() {
+			metrics.Get().TokenVerifyCoalesced.Inc()
+		}
+		<-call.done
+	} else {
+		call.identity, call.err = v.Verifier.Verify(token)
+		v.mutex.Lock()
+		delete(v.calls, key)
+		v.mutex.Unlock()
+		close(call.done)
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+	// every caller gets its own copy of the shared result
+	identity := *call.identity
+	return &identity, nil
+}
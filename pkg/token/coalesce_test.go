@@ -0,0 +1,84 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type blockingVerifier struct {
+	Verifier
+	calls   int32
+	release chan struct{}
+}
+
+func (v *blockingVerifier) Verify(token string) (*Identity, error) {
+	atomic.AddInt32(&v.calls, 1)
+	<-v.release
+	return &Identity{ARN: "acs:ram::123456789012:user/Alice"}, nil
+}
+
+func TestCoalescingVerifierSharesInFlightCall(t *testing.T) {
+	inner := &blockingVerifier{release: make(chan struct{})}
+	v := newCoalescingVerifier(inner)
+
+	const callers = 10
+	var started, finished sync.WaitGroup
+	identities := make([]*Identity, callers)
+	for i := 0; i < callers; i++ {
+		started.Add(1)
+		finished.Add(1)
+		go func(i int) {
+			defer finished.Done()
+			started.Done()
+			identity, err := v.Verify(validToken)
+			if err != nil {
+				t.Errorf("expected error to be nil was %q", err)
+			}
+			identities[i] = identity
+		}(i)
+	}
+	started.Wait()
+	// wait until every other caller is waiting on the in-flight call
+	for {
+		v.mutex.Lock()
+		call, inFlight := v.calls[hashToken(validToken)]
+		waiting := inFlight && call.waiters == callers-1
+		v.mutex.Unlock()
+		if waiting {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(inner.release)
+	finished.Wait()
+
+	if calls := atomic.LoadInt32(&inner.calls); calls != 1 {
+		t.Fatalf("expected 1 STS call but got %d", calls)
+	}
+	for i := 1; i < callers; i++ {
+		if identities[i] == identities[0] {
+			t.Errorf("expected callers to get their own copy of the identity")
+		}
+	}
+	if len(v.calls) != 0 {
+		t.Errorf("expected no in-flight calls to be left, got %d", len(v.calls))
+	}
+}
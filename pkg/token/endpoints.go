@@ -0,0 +1,214 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-tool/pkg/credentials/provider"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+)
+
+// maxSTSRetryBackoff caps the backoff between two attempts of an STS call.
+const maxSTSRetryBackoff = 2 * time.Second
+
+// DefaultSTSEndpoints returns the STS endpoints tried in order for a region:
+// the VPC endpoint of the region, its public endpoint, then the global one.
+func DefaultSTSEndpoints(region string) []string {
+	var endpoints []string
+	seen := sets.NewString()
+	for _, endpoint := range []string{
+		provider.GetSTSEndpoint(region, true),
+		provider.GetSTSEndpoint(region, false),
+		defaultSTSEndpoint,
+	} {
+		if !seen.Has(endpoint) {
+			seen.Insert(endpoint)
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// endpointPool sends STS calls to an ordered list of endpoints. Calls failing
+// with a connection error before anything was sent are retried on the next
+// endpoint after a jittered backoff. An endpoint failing, with a connection
+// error or a server error, breakerFailures times in a row has its circuit
+// breaker opened for breakerCooldown, which moves it after the healthy
+// endpoints until a call succeeds on it again.
+type endpointPool struct {
+	mutex           sync.Mutex
+	endpoints       []*stsEndpoint
+	maxRetries      int
+	backoff         time.Duration
+	breakerFailures int
+	breakerCooldown time.Duration
+	now             func() time.Time
+	sleep           func(time.Duration)
+}
+
+type stsEndpoint struct {
+	host      string
+	failures  int
+	openUntil time.Time
+}
+
+func newEndpointPool(hosts []string, maxRetries int, backoff time.Duration, breakerFailures int, breakerCooldown time.Duration) *endpointPool {
+	p := &endpointPool{
+		maxRetries:      maxRetries,
+		backoff:         backoff,
+		breakerFailures: breakerFailures,
+		breakerCooldown: breakerCooldown,
+		now:             time.Now,
+		sleep:           time.Sleep,
+	}
+	for _, host := range hosts {
+		p.endpoints = append(p.endpoints, &stsEndpoint{host: host})
+		if metrics.Initialized() {
+			metrics.Get().StsEndpointCircuitOpen.WithLabelValues(host).Set(0)
+		}
+	}
+	return p
+}
+
+// open returns true if the circuit breaker of e is open. Once its cooldown
+// is over, calls are let through again to probe the endpoint.
+func (p *endpointPool) open(e *stsEndpoint, now time.Time) bool {
+	return p.breakerFailures > 0 && e.failures >= p.breakerFailures && now.Before(e.openUntil)
+}
+
+// order returns the endpoints to try, the ones with an open circuit breaker
+// last so that they are still tried when every other endpoint fails.
+func (p *endpointPool) order() []*stsEndpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	var healthy, open []*stsEndpoint
+	for _, e := range p.endpoints {
+		if p.open(e, now) {
+			open = append(open, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	return append(healthy, open...)
+}
+
+// record updates the circuit breaker of e with the result of a call.
+func (p *endpointPool) record(e *stsEndpoint, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	if metrics.Initialized() {
+		metrics.Get().StsEndpointRequests.WithLabelValues(e.host, result).Inc()
+	}
+	if p.breakerFailures <= 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	wasOpen := e.failures >= p.breakerFailures
+	if ok {
+		e.failures = 0
+		e.openUntil = time.Time{}
+		if wasOpen {
+			log.WithField("endpoint", e.host).Info("sts endpoint recovered, closing its circuit breaker")
+			if metrics.Initialized() {
+				metrics.Get().StsEndpointCircuitOpen.WithLabelValues(e.host).Set(0)
+			}
+		}
+		return
+	}
+	e.failures++
+	if e.failures >= p.breakerFailures {
+		e.openUntil = p.now().Add(p.breakerCooldown)
+		if !wasOpen {
+			log.WithField("endpoint", e.host).Warnf("sts endpoint failed %d times in a row, opening its circuit breaker for %v", e.failures, p.breakerCooldown)
+			if metrics.Initialized() {
+				metrics.Get().StsEndpointCircuitOpen.WithLabelValues(e.host).Set(1)
+			}
+		}
+	}
+}
+
+// retryBackoff returns the jittered exponential backoff before a retry.
+func (p *endpointPool) retryBackoff(retry int) time.Duration {
+	if p.backoff <= 0 {
+		return 0
+	}
+	backoff := p.backoff << uint(retry-1)
+	if backoff > maxSTSRetryBackoff || backoff <= 0 {
+		backoff = maxSTSRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// do sends an STS request, rewriting its host to the endpoints of the pool.
+// Without a pool, or when failover is false because the signature of the
+// request covers its host, the request is sent as is.
+func (p *endpointPool) do(client *http.Client, req *http.Request, failover bool) (*http.Response, error) {
+	if p == nil || len(p.endpoints) == 0 || !failover {
+		return client.Do(req)
+	}
+
+	endpoints := p.order()
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if metrics.Initialized() {
+				metrics.Get().StsRetries.Inc()
+			}
+			p.sleep(p.retryBackoff(attempt))
+		}
+		endpoint := endpoints[attempt%len(endpoints)]
+		// a request that reached STS must not be sent again: STS records
+		// its signature nonce even when the call fails
+		var sent int32
+		trace := &httptrace.ClientTrace{
+			WroteHeaders: func() { atomic.StoreInt32(&sent, 1) },
+		}
+		attemptReq := req.Clone(httptrace.WithClientTrace(req.Context(), trace))
+		attemptReq.URL.Host = endpoint.host
+		attemptReq.Host = endpoint.host
+
+		response, err := client.Do(attemptReq)
+		if metrics.Initialized() {
+			if err != nil {
+				metrics.Get().StsConnectionFailure.Inc()
+			} else {
+				metrics.Get().StsResponses.WithLabelValues(strconv.Itoa(response.StatusCode)).Inc()
+			}
+		}
+		p.record(endpoint, err == nil && response.StatusCode < http.StatusInternalServerError)
+
+		if err == nil || atomic.LoadInt32(&sent) != 0 || attempt >= p.maxRetries {
+			return response, err
+		}
+		log.WithField("endpoint", endpoint.host).WithError(err).Warn("could not connect to sts endpoint, retrying on the next one")
+	}
+}
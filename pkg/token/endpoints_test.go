@@ -0,0 +1,220 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token/ststest"
+)
+
+// endpointTransport answers the requests of each host with the status code
+// and body configured for it, or a connection error if there is none. A
+// status code of 0 fails the request after it was sent.
+type endpointTransport struct {
+	responses map[string]struct {
+		status int
+		body   string
+	}
+	hosts []string
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.hosts = append(t.hosts, req.URL.Host)
+	response, ok := t.responses[req.URL.Host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+	if response.status == 0 {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &http.Response{StatusCode: response.status, Body: ioutil.NopCloser(strings.NewReader(response.body))}, nil
+}
+
+func (t *endpointTransport) set(host string, status int, body string) {
+	t.responses[host] = struct {
+		status int
+		body   string
+	}{status, body}
+}
+
+func newEndpointTest(breakerFailures int) (*endpointPool, *endpointTransport, *http.Client, *[]time.Duration) {
+	transport := &endpointTransport{responses: map[string]struct {
+		status int
+		body   string
+	}{}}
+	p := newEndpointPool([]string{"vpc", "public", "global"}, 2, 100*time.Millisecond, breakerFailures, time.Minute)
+	var sleeps []time.Duration
+	p.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return p, transport, &http.Client{Transport: transport}, &sleeps
+}
+
+func get(t *testing.T, p *endpointPool, client *http.Client) (int, error) {
+	req, _ := http.NewRequest(http.MethodGet, "https://sts.aliyuncs.com/?Action=GetCallerIdentity", nil)
+	response, err := p.do(client, req, true)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+func TestDefaultSTSEndpoints(t *testing.T) {
+	if endpoints := DefaultSTSEndpoints("cn-hangzhou"); !reflect.DeepEqual(endpoints, []string{"sts-vpc.cn-hangzhou.aliyuncs.com", "sts.cn-hangzhou.aliyuncs.com", "sts.aliyuncs.com"}) {
+		t.Errorf("unexpected endpoints %v", endpoints)
+	}
+	if endpoints := DefaultSTSEndpoints(""); !reflect.DeepEqual(endpoints, []string{"sts.aliyuncs.com"}) {
+		t.Errorf("unexpected endpoints %v", endpoints)
+	}
+}
+
+func TestEndpointPoolRetriesOnTheNextEndpoint(t *testing.T) {
+	p, transport, client, sleeps := newEndpointTest(0)
+	transport.set("global", http.StatusOK, `{}`)
+
+	if status, err := get(t, p, client); err != nil || status != http.StatusOK {
+		t.Fatalf("expected the global endpoint to answer but got %d %v", status, err)
+	}
+	if !reflect.DeepEqual(transport.hosts, []string{"vpc", "public", "global"}) {
+		t.Errorf("unexpected endpoints tried %v", transport.hosts)
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] < 50*time.Millisecond || (*sleeps)[0] > 100*time.Millisecond || (*sleeps)[1] < 100*time.Millisecond || (*sleeps)[1] > 200*time.Millisecond {
+		t.Errorf("expected jittered exponential backoffs but got %v", *sleeps)
+	}
+
+	transport.hosts = nil
+	delete(transport.responses, "global")
+	if _, err := get(t, p, client); err == nil || len(transport.hosts) != 3 {
+		t.Errorf("expected the last connection error after %d retries but got %v after %v", p.maxRetries, err, transport.hosts)
+	}
+}
+
+func TestEndpointPoolDoesNotResendRequestsThatReachedSTS(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{"client error", http.StatusForbidden},
+		{"server error", http.StatusServiceUnavailable},
+		{"connection reset", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, transport, client, _ := newEndpointTest(0)
+			transport.set("vpc", tc.status, ``)
+			transport.set("public", http.StatusOK, `{}`)
+
+			status, err := get(t, p, client)
+			if status != tc.status || (err != nil) != (tc.status == 0) {
+				t.Errorf("expected the first response but got %d %v", status, err)
+			}
+			if !reflect.DeepEqual(transport.hosts, []string{"vpc"}) {
+				t.Errorf("expected the request to be sent once but tried %v", transport.hosts)
+			}
+		})
+	}
+}
+
+func TestEndpointPoolWithoutFailover(t *testing.T) {
+	p, transport, client, _ := newEndpointTest(0)
+	transport.set("public", http.StatusOK, `{}`)
+
+	req, _ := http.NewRequest(http.MethodPost, "https://vpc/", nil)
+	if _, err := p.do(client, req, false); err == nil {
+		t.Error("expected the connection error of the request's own host")
+	}
+	if !reflect.DeepEqual(transport.hosts, []string{"vpc"}) {
+		t.Errorf("expected the request to be sent to its own host only but tried %v", transport.hosts)
+	}
+}
+
+func TestEndpointPoolCircuitBreaker(t *testing.T) {
+	p, transport, client, _ := newEndpointTest(2)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	transport.set("public", http.StatusOK, `{}`)
+
+	for i := 0; i < 2; i++ {
+		get(t, p, client)
+	}
+	transport.hosts = nil
+	get(t, p, client)
+	if !reflect.DeepEqual(transport.hosts, []string{"public"}) {
+		t.Errorf("expected the open endpoint to be skipped but tried %v", transport.hosts)
+	}
+
+	delete(transport.responses, "public")
+	transport.hosts = nil
+	get(t, p, client)
+	if !reflect.DeepEqual(transport.hosts, []string{"public", "global", "vpc"}) {
+		t.Errorf("expected the open endpoint to be tried last but tried %v", transport.hosts)
+	}
+
+	now = now.Add(2 * time.Minute)
+	transport.set("vpc", http.StatusOK, `{}`)
+	transport.hosts = nil
+	get(t, p, client)
+	if transport.hosts[0] != "vpc" {
+		t.Errorf("expected the endpoint to be probed after the cooldown but tried %v", transport.hosts)
+	}
+	if p.open(p.endpoints[0], now) {
+		t.Error("expected a successful probe to close the circuit breaker")
+	}
+}
+
+func TestVerifyFailsOverToHealthyEndpoint(t *testing.T) {
+	server := ststest.NewServer()
+	defer server.Close()
+	user := server.AddUser("123456789012", "Alice", "300800000000000000")
+	v := NewVerifierWithOptions(VerifierOptions{
+		ClusterID:     "cluster",
+		STSEndpoints:  []string{"127.0.0.1:1", server.Endpoint()},
+		STSMaxRetries: 1,
+		RootCAs:       server.RootCAs(),
+	})
+
+	identity, err := v.Verify(ststest.V1Token(user, "cluster", time.Now()))
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if identity.ARN != "acs:ram::123456789012:user/Alice" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestVerifyDoesNotMoveV2TokensAcrossHosts(t *testing.T) {
+	p, transport, client, _ := newEndpointTest(0)
+	transport.set("public", http.StatusOK, `{}`)
+	v := tokenVerifier{client: client, clusterID: "cluster", stsEndpoint: "vpc", endpoints: p}
+	user := ststest.Credential{AccessKeyID: "LTAI", AccessKeySecret: "secret"}
+
+	if _, err := v.Verify(ststest.V2Token(user, "cluster", time.Now())); err == nil {
+		t.Error("expected the connection error of the first endpoint")
+	}
+	if !reflect.DeepEqual(transport.hosts, []string{"vpc"}) {
+		t.Errorf("expected the v2 token to be sent to the first endpoint only but tried %v", transport.hosts)
+	}
+}
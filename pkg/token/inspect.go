@@ -0,0 +1,117 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenInfo holds the parts of a token that can be read without calling STS.
+type tokenInfo struct {
+	// issuedAt is the signing time of the presigned request.
+	issuedAt time.Time
+	// nonce is the signature nonce, unique to every signed request.
+	nonce string
+	// accessKeyID is the AccessKeyID the token was signed with.
+	accessKeyID string
+}
+
+// expiresAt returns the time after which STS rejects the presigned request.
+func (i tokenInfo) expiresAt() time.Time {
+	return i.issuedAt.Add(presignedURLExpiration)
+}
+
+// Version returns the version of a token, "v1" or "v2", judging only by its
+// prefix, or "" if it has neither.
+func Version(token string) string {
+	switch {
+	case strings.HasPrefix(token, v1Prefix):
+		return "v1"
+	case strings.HasPrefix(token, v2Prefix):
+		return "v2"
+	}
+	return ""
+}
+
+// inspectToken decodes a token locally. It does not check that the token is
+// valid, only Verify can do that.
+func inspectToken(token string) (tokenInfo, error) {
+	var info tokenInfo
+	var rawTimestamp string
+
+	switch {
+	case strings.HasPrefix(token, v1Prefix):
+		tokenBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, v1Prefix))
+		if err != nil {
+			return info, err
+		}
+		parsedURL, err := url.Parse(string(tokenBytes))
+		if err != nil {
+			return info, err
+		}
+		for key, values := range parsedURL.Query() {
+			if len(values) == 0 {
+				continue
+			}
+			switch strings.ToLower(key) {
+			case "timestamp":
+				rawTimestamp = values[0]
+			case "signaturenonce":
+				info.nonce = values[0]
+			case "accesskeyid":
+				info.accessKeyID = values[0]
+			}
+		}
+	case strings.HasPrefix(token, v2Prefix):
+		tokenBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, v2Prefix))
+		if err != nil {
+			return info, err
+		}
+		var t V2Token
+		if err := json.Unmarshal(tokenBytes, &t); err != nil {
+			return info, err
+		}
+		for key, value := range t.Headers {
+			switch strings.ToLower(key) {
+			case "x-acs-date":
+				rawTimestamp = value
+			case "x-acs-signature-nonce":
+				info.nonce = value
+			case "authorization":
+				info.accessKeyID = getAccessKeyIdFromV2Header(value)
+			}
+		}
+	default:
+		return info, fmt.Errorf("token is missing expected prefix")
+	}
+
+	if rawTimestamp == "" {
+		return info, fmt.Errorf("token has no signing timestamp")
+	}
+	issuedAt, err := time.Parse(timeFormat, rawTimestamp)
+	if err != nil {
+		return info, fmt.Errorf("invalid signing timestamp %q: %v", rawTimestamp, err)
+	}
+	info.issuedAt = issuedAt
+
+	return info, nil
+}
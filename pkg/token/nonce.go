@@ -0,0 +1,318 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplayError is returned when the signature nonce of a token was already
+// used more often than the NoncePolicy allows.
+type ReplayError struct {
+	message string
+}
+
+func (e ReplayError) Error() string {
+	return "token replayed: " + e.message
+}
+
+// NonceUse is the state of a signature nonce after a use was recorded.
+type NonceUse struct {
+	// Client is the client that first used the nonce.
+	Client string
+	// FirstUsed is when the nonce was first used.
+	FirstUsed time.Time
+	// Uses is the number of uses recorded, including the last one.
+	Uses int
+}
+
+// NonceStore records the uses of the signature nonces of verified tokens. A
+// store shared by the replicas of the server protects all of them against
+// replays.
+type NonceStore interface {
+	// Lookup returns the uses of nonce recorded so far without recording
+	// one. It returns false when the nonce was not used or has expired.
+	Lookup(nonce string, now time.Time) (NonceUse, bool, error)
+	// Use atomically records a use of nonce by client at now and returns its
+	// uses so far. The nonce can be forgotten after expiresAt.
+	Use(nonce, client string, now, expiresAt time.Time) (NonceUse, error)
+}
+
+// NoncePolicy is how often the same token is accepted.
+type NoncePolicy struct {
+	// MaxReuses is how many times the client that first used a token may use
+	// it again. Other clients may never use it.
+	MaxReuses int
+	// ReuseWindow is how long after its first use a token may be reused.
+	// Reuses are allowed until the token expires when it is 0.
+	ReuseWindow time.Duration
+}
+
+// check returns a ReplayError if use, the latest use of a token by client,
+// is not allowed.
+func (p NoncePolicy) check(use NonceUse, client string, now time.Time) error {
+	switch {
+	case use.Uses <= 1:
+		return nil
+	case use.Client != client:
+		return ReplayError{"token was already used by another client"}
+	case use.Uses-1 > p.MaxReuses:
+		return ReplayError{fmt.Sprintf("token was already used %d times", use.Uses-1)}
+	case p.ReuseWindow > 0 && now.Sub(use.FirstUsed) > p.ReuseWindow:
+		return ReplayError{fmt.Sprintf("token was first used more than %v ago", p.ReuseWindow)}
+	}
+	return nil
+}
+
+// localNonceStore is an in-process NonceStore. Once full, the nonces that
+// expire first are forgotten to make room, as their tokens are the closest
+// to being rejected as expired anyway.
+type localNonceStore struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*nonceEntry
+	// expiries orders the entries by expiry.
+	expiries nonceExpiries
+}
+
+type nonceEntry struct {
+	nonce     string
+	use       NonceUse
+	expiresAt time.Time
+	index     int
+}
+
+// NewLocalNonceStore returns an in-process NonceStore remembering up to size
+// unexpired nonces.
+func NewLocalNonceStore(size int) NonceStore {
+	return &localNonceStore{size: size, entries: map[string]*nonceEntry{}}
+}
+
+func (s *localNonceStore) Lookup(nonce string, now time.Time) (NonceUse, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.entries[nonce]
+	if !ok || !now.Before(entry.expiresAt) {
+		return NonceUse{}, false, nil
+	}
+	return entry.use, true, nil
+}
+
+func (s *localNonceStore) Use(nonce, client string, now, expiresAt time.Time) (NonceUse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.expiries) > 0 && !now.Before(s.expiries[0].expiresAt) {
+		delete(s.entries, heap.Pop(&s.expiries).(*nonceEntry).nonce)
+	}
+	if entry, ok := s.entries[nonce]; ok {
+		entry.use.Uses++
+		return entry.use, nil
+	}
+	if len(s.entries) >= s.size {
+		evicted := heap.Pop(&s.expiries).(*nonceEntry)
+		delete(s.entries, evicted.nonce)
+		log.Warnf("nonce store is full, forgetting a nonce %v before it expires", evicted.expiresAt.Sub(now))
+		if metrics.Initialized() {
+			metrics.Get().TokenNonceEvictions.Inc()
+		}
+	}
+	entry := &nonceEntry{
+		nonce:     nonce,
+		use:       NonceUse{Client: client, FirstUsed: now, Uses: 1},
+		expiresAt: expiresAt,
+	}
+	s.entries[nonce] = entry
+	heap.Push(&s.expiries, entry)
+	return entry.use, nil
+}
+
+// nonceExpiries is a heap.Interface of nonce entries, the first to expire
+// first.
+type nonceExpiries []*nonceEntry
+
+func (e nonceExpiries) Len() int           { return len(e) }
+func (e nonceExpiries) Less(i, j int) bool { return e[i].expiresAt.Before(e[j].expiresAt) }
+
+func (e nonceExpiries) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+	e[i].index = i
+	e[j].index = j
+}
+
+func (e *nonceExpiries) Push(x interface{}) {
+	entry := x.(*nonceEntry)
+	entry.index = len(*e)
+	*e = append(*e, entry)
+}
+
+func (e *nonceExpiries) Pop() interface{} {
+	old := *e
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*e = old[:len(old)-1]
+	return entry
+}
+
+// NonceBackend is the API of an external key-value store, such as Redis or
+// etcd, that NewExternalNonceStore needs.
+type NonceBackend interface {
+	// Get returns the value stored by the first use of key and the number of
+	// uses so far. It returns false when key does not exist.
+	Get(key string) (first string, uses int, ok bool, err error)
+	// Record atomically records a use of key. The first use stores value and
+	// expires the key after ttl. It returns the value stored by the first
+	// use and the number of uses, including this one.
+	Record(key, value string, ttl time.Duration) (first string, uses int, err error)
+}
+
+// externalNonceStore is a NonceStore kept in a NonceBackend.
+type externalNonceStore struct {
+	backend NonceBackend
+	prefix  string
+}
+
+// NewExternalNonceStore returns a NonceStore keeping nonces in backend, under
+// keys starting with prefix.
+func NewExternalNonceStore(backend NonceBackend, prefix string) NonceStore {
+	return &externalNonceStore{backend: backend, prefix: prefix}
+}
+
+func (s *externalNonceStore) Lookup(nonce string, now time.Time) (NonceUse, bool, error) {
+	first, uses, ok, err := s.backend.Get(s.prefix + nonce)
+	if err != nil || !ok {
+		return NonceUse{}, false, err
+	}
+	use, err := parseNonceValue(nonce, first)
+	if err != nil {
+		return NonceUse{}, false, err
+	}
+	use.Uses = uses
+	return use, true, nil
+}
+
+func (s *externalNonceStore) Use(nonce, client string, now, expiresAt time.Time) (NonceUse, error) {
+	ttl := expiresAt.Sub(now)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	first, uses, err := s.backend.Record(s.prefix+nonce, strconv.FormatInt(now.UnixNano(), 10)+" "+client, ttl)
+	if err != nil {
+		return NonceUse{}, err
+	}
+	use, err := parseNonceValue(nonce, first)
+	if err != nil {
+		return NonceUse{}, err
+	}
+	use.Uses = uses
+	return use, nil
+}
+
+// parseNonceValue parses the value stored by the first use of a nonce,
+// "<first use in Unix nanoseconds> <client>".
+func parseNonceValue(nonce, value string) (NonceUse, error) {
+	parts := strings.SplitN(value, " ", 2)
+	firstUsed, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		return NonceUse{}, fmt.Errorf("unexpected value %q of nonce %s", value, nonce)
+	}
+	return NonceUse{Client: parts[1], FirstUsed: time.Unix(0, firstUsed)}, nil
+}
+
+// nonceVerifier rejects tokens whose signature nonce was used more often than
+// its policy allows. It must wrap the cachingVerifier, so that replays served
+// from the cache are counted too.
+type nonceVerifier struct {
+	Verifier
+	store  NonceStore
+	policy NoncePolicy
+	now    func() time.Time
+}
+
+func newNonceVerifier(verifier Verifier, store NonceStore, policy NoncePolicy) *nonceVerifier {
+	return &nonceVerifier{
+		Verifier: verifier,
+		store:    store,
+		policy:   policy,
+		now:      time.Now,
+	}
+}
+
+// VerifyAndRecord verifies a token presented by client and records the use
+// of its nonce. Tokens whose recorded uses already exhaust the policy are
+// rejected before they are verified; only the uses of verified tokens are
+// recorded, so that invalid tokens can't fill the store.
+func (v *nonceVerifier) VerifyAndRecord(token, client string) (*Identity, error) {
+	info, err := inspectToken(token)
+	if err != nil {
+		return nil, FormatError{err.Error()}
+	}
+	if info.nonce == "" {
+		return nil, FormatError{"token has no signature nonce"}
+	}
+
+	now := v.now()
+	use, ok, err := v.store.Lookup(info.nonce, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up the nonce of the token: %v", err)
+	}
+	if ok {
+		use.Uses++
+		if err := v.policy.check(use, client, now); err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err := v.Verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	// concurrent uses of the same token all pass the lookup, the recorded
+	// uses decide which of them are replays
+	use, err = v.store.Use(info.nonce, client, now, info.expiresAt())
+	if err != nil {
+		return nil, fmt.Errorf("could not record the nonce of the token: %v", err)
+	}
+	if err := v.policy.check(use, client, now); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// recordingVerifier is a Verifier that records the tokens it verifies.
+type recordingVerifier interface {
+	VerifyAndRecord(token, client string) (*Identity, error)
+}
+
+// VerifyAndRecord verifies a token presented to the webhook by client, the
+// address of its caller. Replay protection only applies to tokens verified
+// with VerifyAndRecord; Verify does not record the use of a token.
+func VerifyAndRecord(v Verifier, token, client string) (*Identity, error) {
+	if rv, ok := v.(recordingVerifier); ok {
+		return rv.VerifyAndRecord(token, client)
+	}
+	return v.Verify(token)
+}
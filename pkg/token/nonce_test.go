@@ -0,0 +1,217 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNonceVerifierRejectsReplays(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{ARN: "acs:ram::123456789012:user/Alice"}}
+	v := newNonceVerifier(inner, NewLocalNonceStore(10), NoncePolicy{MaxReuses: 1})
+
+	for i := 0; i < 2; i++ {
+		if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+			t.Fatalf("use %d: expected error to be nil was %q", i, err)
+		}
+	}
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); !errors.As(err, &ReplayError{}) {
+		t.Errorf("expected a ReplayError once the reuses are exhausted but got %v", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected the replay to be rejected before verifying it but verified %d times", inner.calls)
+	}
+}
+
+func TestNonceVerifierRejectsOtherClients(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{}}
+	v := newNonceVerifier(inner, NewLocalNonceStore(10), NoncePolicy{MaxReuses: 10})
+
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.2"); !errors.As(err, &ReplayError{}) {
+		t.Errorf("expected a ReplayError for another client but got %v", err)
+	}
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+		t.Errorf("expected the first client to reuse the token but got %q", err)
+	}
+}
+
+func TestNonceVerifierReuseWindow(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{}}
+	v := newNonceVerifier(inner, NewLocalNonceStore(10), NoncePolicy{MaxReuses: 10, ReuseWindow: time.Minute})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+		t.Fatalf("expected a reuse within the window to be accepted but got %q", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); !errors.As(err, &ReplayError{}) {
+		t.Errorf("expected a ReplayError after the window but got %v", err)
+	}
+}
+
+func TestNonceVerifierOnlyRecordsVerifiedTokens(t *testing.T) {
+	inner := &countingVerifier{err: FormatError{"invalid"}}
+	v := newNonceVerifier(inner, NewLocalNonceStore(1), NoncePolicy{})
+
+	for i := 0; i < 3; i++ {
+		token := toToken(v1URL(map[string]string{"SignatureNonce": strconv.Itoa(i)}))
+		if _, err := VerifyAndRecord(v, token, "10.0.0.1"); !errors.As(err, &FormatError{}) {
+			t.Fatalf("expected the error of the inner verifier but got %v", err)
+		}
+	}
+	inner.err = nil
+	inner.identity = &Identity{}
+	if _, err := v.Verify(validToken); err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); err != nil {
+		t.Errorf("expected the first recorded use to be accepted but got %q", err)
+	}
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); !errors.As(err, &ReplayError{}) {
+		t.Errorf("expected the invalid tokens not to evict the recorded use but got %v", err)
+	}
+	if _, err := VerifyAndRecord(v, toToken(v1URL(map[string]string{"SignatureNonce": ""})), "10.0.0.1"); !errors.As(err, &FormatError{}) {
+		t.Errorf("expected a FormatError for a token without nonce but got %v", err)
+	}
+}
+
+// racingVerifier records a use of the nonce of every token it verifies by
+// another client, like a replica verifying the same token concurrently.
+type racingVerifier struct {
+	Verifier
+	store NonceStore
+}
+
+func (v *racingVerifier) Verify(token string) (*Identity, error) {
+	info, err := inspectToken(token)
+	if err != nil {
+		return nil, err
+	}
+	v.store.Use(info.nonce, "10.0.0.2", time.Now(), info.expiresAt())
+	return v.Verifier.Verify(token)
+}
+
+func TestNonceVerifierRecordsConcurrentUses(t *testing.T) {
+	store := NewLocalNonceStore(10)
+	inner := &racingVerifier{Verifier: &countingVerifier{identity: &Identity{}}, store: store}
+	v := newNonceVerifier(inner, store, NoncePolicy{MaxReuses: 10})
+
+	if _, err := VerifyAndRecord(v, validToken, "10.0.0.1"); !errors.As(err, &ReplayError{}) {
+		t.Errorf("expected a ReplayError for the token used concurrently by another client but got %v", err)
+	}
+}
+
+func TestVerifyAndRecordWithoutNonceStore(t *testing.T) {
+	inner := &countingVerifier{identity: &Identity{}}
+	for i := 0; i < 3; i++ {
+		if _, err := VerifyAndRecord(inner, validToken, "10.0.0.1"); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+	}
+}
+
+func TestLocalNonceStoreForgetsExpiredNonces(t *testing.T) {
+	s := NewLocalNonceStore(10)
+	now := time.Now()
+	s.Use("nonce", "client", now, now.Add(time.Minute))
+	use, _ := s.Use("nonce", "client", now.Add(30*time.Second), now.Add(time.Minute))
+	if use.Uses != 2 || !use.FirstUsed.Equal(now) || use.Client != "client" {
+		t.Errorf("expected the second use since %v but got %+v", now, use)
+	}
+	if _, ok, _ := s.Lookup("nonce", now.Add(2*time.Minute)); ok {
+		t.Errorf("expected the lookup to ignore the expired nonce")
+	}
+	use, _ = s.Use("nonce", "other", now.Add(2*time.Minute), now.Add(3*time.Minute))
+	if use.Uses != 1 || !use.FirstUsed.Equal(now.Add(2*time.Minute)) || use.Client != "other" {
+		t.Errorf("expected the expired nonce to be forgotten but got %+v", use)
+	}
+}
+
+func TestLocalNonceStoreEvictsFirstToExpireWhenFull(t *testing.T) {
+	s := NewLocalNonceStore(2)
+	now := time.Now()
+	s.Use("early", "client", now, now.Add(time.Minute))
+	s.Use("late", "client", now, now.Add(2*time.Minute))
+
+	if use, err := s.Use("new", "client", now, now.Add(time.Minute)); err != nil || use.Uses != 1 {
+		t.Fatalf("expected a new nonce to be recorded while the store is full but got %+v %v", use, err)
+	}
+	if _, ok, _ := s.Lookup("early", now); ok {
+		t.Errorf("expected the nonce expiring first to be evicted")
+	}
+	if use, ok, _ := s.Lookup("late", now); !ok || use.Uses != 1 {
+		t.Errorf("expected the nonce expiring last to be kept but got %+v", use)
+	}
+}
+
+// mapNonceBackend is a NonceBackend in a map that never expires keys.
+type mapNonceBackend struct {
+	values map[string]string
+	uses   map[string]int
+	ttls   map[string]time.Duration
+}
+
+func (b *mapNonceBackend) Get(key string) (string, int, bool, error) {
+	value, ok := b.values[key]
+	return value, b.uses[key], ok, nil
+}
+
+func (b *mapNonceBackend) Record(key, value string, ttl time.Duration) (string, int, error) {
+	if _, ok := b.values[key]; !ok {
+		b.values[key] = value
+		b.ttls[key] = ttl
+	}
+	b.uses[key]++
+	return b.values[key], b.uses[key], nil
+}
+
+func TestExternalNonceStore(t *testing.T) {
+	backend := &mapNonceBackend{values: map[string]string{}, uses: map[string]int{}, ttls: map[string]time.Duration{}}
+	s := NewExternalNonceStore(backend, "nonces/")
+	now := time.Unix(1600000000, 0)
+
+	if _, ok, err := s.Lookup("nonce", now); ok || err != nil {
+		t.Fatalf("expected an unknown nonce but got %v %v", ok, err)
+	}
+	s.Use("nonce", "10.0.0.1", now, now.Add(time.Minute))
+	use, err := s.Use("nonce", "10.0.0.2", now.Add(time.Second), now.Add(time.Minute))
+	if err != nil || use.Uses != 2 || use.Client != "10.0.0.1" || !use.FirstUsed.Equal(now) {
+		t.Errorf("expected the second use by the first client but got %+v %v", use, err)
+	}
+	if use, ok, err := s.Lookup("nonce", now); !ok || err != nil || use.Uses != 2 || use.Client != "10.0.0.1" {
+		t.Errorf("expected the lookup to return the recorded uses but got %+v %v %v", use, ok, err)
+	}
+	if ttl := backend.ttls["nonces/nonce"]; ttl != time.Minute {
+		t.Errorf("expected the key to expire with the token but got ttl %v", ttl)
+	}
+
+	backend.values["nonces/bad"] = "garbage"
+	if _, _, err := s.Lookup("bad", now); err == nil {
+		t.Errorf("expected an error for an unexpected value")
+	}
+}
@@ -23,6 +23,8 @@ func newOpenAPIErr(statusCode int, body []byte, rawErr error) STSError {
 	stsErr := STSError{
 		raiseToUser: true,
 		message:     fmt.Sprintf("call sts.GetCallerIdentity failed: %s", http.StatusText(statusCode)),
+		statusCode:  statusCode,
+		code:        resp.Code,
 	}
 	if rawErr != nil && rawErr.Error() != "" {
 		stsErr.message = fmt.Sprintf("%s, %s", stsErr.message, rawErr.Error())
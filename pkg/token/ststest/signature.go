@@ -0,0 +1,333 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ststest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	timeFormat = "2006-01-02T15:04:05Z"
+
+	v1Prefix = "k8s-ack-v1."
+	v2Prefix = "k8s-ack-v2."
+
+	v1SignatureMethod  = "HMAC-SHA1"
+	v1SignatureVersion = "1.0"
+	v2Algorithm        = "ACS3-HMAC-SHA256"
+)
+
+// request is a parsed STS request of either signature version.
+type request struct {
+	action        string
+	accessKeyID   string
+	securityToken string
+	nonce         string
+	timestamp     time.Time
+	// params are the API parameters, from the query and the form body.
+	params map[string]string
+	// signedWith reports whether the request is signed with a secret.
+	signedWith func(secret string) bool
+}
+
+func (s *Server) parse(r *http.Request) (*request, error) {
+	if r.URL.Path != "/" {
+		return nil, &apiError{http.StatusNotFound, "InvalidAction.NotFound", "Specified api is not found, please check your url and method."}
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "InvalidParameter", err.Error()}
+	}
+	params, err := parameters(r, body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), v2Algorithm+" ") {
+		return parseV2(r, body, params)
+	}
+	return parseV1(r, params)
+}
+
+// parameters merges the query and the form body of a request.
+func parameters(r *http.Request, body []byte) (map[string]string, error) {
+	values := r.URL.Query()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "InvalidParameter", err.Error()}
+		}
+		for key, formValues := range form {
+			values[key] = append(values[key], formValues...)
+		}
+	}
+	params := map[string]string{}
+	for key, keyValues := range values {
+		if len(keyValues) != 1 {
+			return nil, &apiError{http.StatusBadRequest, "InvalidParameter", fmt.Sprintf("Parameter %s is specified more than once.", key)}
+		}
+		params[key] = keyValues[0]
+	}
+	return params, nil
+}
+
+// parseV1 parses a request signed in its query with HMAC-SHA1.
+func parseV1(r *http.Request, params map[string]string) (*request, error) {
+	for _, key := range []string{"AccessKeyId", "Signature", "SignatureMethod", "SignatureVersion", "SignatureNonce", "Timestamp", "Action", "Version"} {
+		if params[key] == "" {
+			return nil, &apiError{http.StatusBadRequest, "Missing" + key, key + " is mandatory for this action."}
+		}
+	}
+	if params["SignatureMethod"] != v1SignatureMethod {
+		return nil, &apiError{http.StatusBadRequest, "InvalidSignatureMethod", "Specified signature method is not supported."}
+	}
+	if params["SignatureVersion"] != v1SignatureVersion {
+		return nil, &apiError{http.StatusBadRequest, "InvalidSignatureVersion", "Specified signature version is not supported."}
+	}
+	if params["Version"] != APIVersion {
+		return nil, &apiError{http.StatusBadRequest, "InvalidVersion", "Specified parameter Version is not valid."}
+	}
+	timestamp, err := time.Parse(timeFormat, params["Timestamp"])
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "InvalidTimeStamp.Format", "Specified time stamp or date value is not well formatted."}
+	}
+
+	signature := params["Signature"]
+	return &request{
+		action:        params["Action"],
+		accessKeyID:   params["AccessKeyId"],
+		securityToken: params["SecurityToken"],
+		nonce:         params["SignatureNonce"],
+		timestamp:     timestamp,
+		params:        params,
+		signedWith: func(secret string) bool {
+			return hmac.Equal([]byte(signature), []byte(signV1(r.Method, params, secret)))
+		},
+	}, nil
+}
+
+// signV1 returns the HMAC-SHA1 signature of the parameters of a request.
+func signV1(method string, params map[string]string, secret string) string {
+	var keys []string
+	for key := range params {
+		if key != "Signature" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, percentEncode(key)+"="+percentEncode(params[key]))
+	}
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseV2 parses a request signed in its Authorization header with
+// ACS3-HMAC-SHA256.
+func parseV2(r *http.Request, body []byte, params map[string]string) (*request, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), v2Algorithm+" "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	if fields["Credential"] == "" || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return nil, &apiError{http.StatusBadRequest, "InvalidAuthorization", "Specified Authorization header is not well formatted."}
+	}
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	signed := map[string]bool{}
+	for _, header := range signedHeaders {
+		signed[header] = true
+	}
+	for name := range r.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-acs-") && !signed[name] {
+			return nil, &apiError{http.StatusBadRequest, "IncompleteSignature", fmt.Sprintf("Header %s is not signed.", name)}
+		}
+	}
+	for _, header := range []string{"x-acs-action", "x-acs-version", "x-acs-date", "x-acs-signature-nonce", "x-acs-content-sha256"} {
+		if r.Header.Get(header) == "" {
+			return nil, &apiError{http.StatusBadRequest, "MissingHeader", header + " is mandatory for this action."}
+		}
+	}
+	if r.Header.Get("x-acs-version") != APIVersion {
+		return nil, &apiError{http.StatusBadRequest, "InvalidVersion", "Specified parameter Version is not valid."}
+	}
+	timestamp, err := time.Parse(timeFormat, r.Header.Get("x-acs-date"))
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "InvalidTimeStamp.Format", "Specified time stamp or date value is not well formatted."}
+	}
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("x-acs-content-sha256") != hex.EncodeToString(payloadHash[:]) {
+		return nil, &apiError{http.StatusBadRequest, "ContentSHA256NotMatched", "Specified content SHA256 is not matched with our calculation."}
+	}
+
+	headers := map[string]string{}
+	for _, name := range signedHeaders {
+		if name == "host" {
+			headers[name] = r.Host
+		} else {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	signature := fields["Signature"]
+	return &request{
+		action:        r.Header.Get("x-acs-action"),
+		accessKeyID:   fields["Credential"],
+		securityToken: r.Header.Get("x-acs-security-token"),
+		nonce:         r.Header.Get("x-acs-signature-nonce"),
+		timestamp:     timestamp,
+		params:        params,
+		signedWith: func(secret string) bool {
+			expected := signV2(r.Method, r.URL.Query(), headers, hex.EncodeToString(payloadHash[:]), secret)
+			return hmac.Equal([]byte(signature), []byte(expected))
+		},
+	}, nil
+}
+
+// signV2 returns the ACS3-HMAC-SHA256 signature of a request to "/" whose
+// signed headers are headers.
+func signV2(method string, query url.Values, headers map[string]string, payloadHash, secret string) string {
+	var queryKeys []string
+	for key := range query {
+		queryKeys = append(queryKeys, key)
+	}
+	sort.Strings(queryKeys)
+	var queryPairs []string
+	for _, key := range queryKeys {
+		queryPairs = append(queryPairs, percentEncode(key)+"="+percentEncode(query.Get(key)))
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		"/",
+		strings.Join(queryPairs, "&"),
+		canonicalHeaders,
+		strings.Join(names, ";"),
+		payloadHash,
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := v2Algorithm + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// percentEncode encodes a string the way Alibaba Cloud signatures do, which
+// differs from url.QueryEscape for spaces, "*" and "~".
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+// V1Token returns a v1 token for clusterID, a GetCallerIdentity URL signed
+// with credential at now.
+func V1Token(credential Credential, clusterID string, now time.Time) string {
+	params := map[string]string{
+		"Action":           "GetCallerIdentity",
+		"Version":          APIVersion,
+		"Format":           "JSON",
+		"AccessKeyId":      credential.AccessKeyID,
+		"SignatureMethod":  v1SignatureMethod,
+		"SignatureVersion": v1SignatureVersion,
+		"SignatureNonce":   uuid.NewV4().String(),
+		"Timestamp":        now.UTC().Format(timeFormat),
+		"ClusterId":        clusterID,
+	}
+	if credential.SecurityToken != "" {
+		params["SecurityToken"] = credential.SecurityToken
+	}
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	query.Set("Signature", signV1(http.MethodGet, params, credential.AccessKeySecret))
+	rawURL := "https://sts.aliyuncs.com/?" + query.Encode()
+	return v1Prefix + base64.StdEncoding.EncodeToString([]byte(rawURL))
+}
+
+// v2Token is the JSON encoded in a v2 token.
+type v2Token struct {
+	ClusterID string            `json:"clusterId"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Query     map[string]string `json:"query"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// V2Token returns a v2 token for clusterID, the headers of a GetCallerIdentity
+// request signed with credential at now.
+func V2Token(credential Credential, clusterID string, now time.Time) string {
+	payloadHash := sha256.Sum256(nil)
+	headers := map[string]string{
+		"x-acs-action":          "GetCallerIdentity",
+		"x-acs-version":         APIVersion,
+		"x-acs-date":            now.UTC().Format(timeFormat),
+		"x-acs-signature-nonce": uuid.NewV4().String(),
+		"x-acs-content-sha256":  hex.EncodeToString(payloadHash[:]),
+	}
+	if credential.SecurityToken != "" {
+		headers["x-acs-security-token"] = credential.SecurityToken
+	}
+	signature := signV2(http.MethodPost, nil, headers, headers["x-acs-content-sha256"], credential.AccessKeySecret)
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	headers["Authorization"] = fmt.Sprintf("%s Credential=%s,SignedHeaders=%s,Signature=%s",
+		v2Algorithm, credential.AccessKeyID, strings.Join(names, ";"), signature)
+
+	token, _ := json.Marshal(v2Token{
+		ClusterID: clusterID,
+		Method:    http.MethodPost,
+		Path:      "/",
+		Query:     map[string]string{},
+		Headers:   headers,
+	})
+	return v2Prefix + base64.StdEncoding.EncodeToString(token)
+}
@@ -0,0 +1,429 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ststest provides a local stand-in for the Alibaba Cloud STS API, so
+// that tokens can be generated and verified end to end without network
+// access.
+//
+// The server checks the v1 (HMAC-SHA1 query signature) and v2
+// (ACS3-HMAC-SHA256 Authorization header) signatures of requests against the
+// credentials added to it, enforces the timestamp and nonce rules of STS, and
+// answers GetCallerIdentity and AssumeRole with the JSON bodies and error
+// codes of the real API.
+package ststest
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// APIVersion is the STS API version served.
+	APIVersion = "2015-04-01"
+
+	// MaxClockSkew is how far the timestamp of a request may be from the
+	// server's clock. Older requests are expired, later ones rejected.
+	MaxClockSkew = 15 * time.Minute
+
+	IdentityTypeRAMUser         = "RAMUser"
+	IdentityTypeAssumedRoleUser = "AssumedRoleUser"
+	IdentityTypeAccount         = "Account"
+
+	defaultAssumeRoleDuration = time.Hour
+	minAssumeRoleDuration     = 15 * time.Minute
+	maxAssumeRoleDuration     = 12 * time.Hour
+)
+
+// Identity is the caller GetCallerIdentity returns for a credential.
+type Identity struct {
+	AccountID string
+	ARN       string
+	// PrincipalID is "<role ID>:<session name>" for assumed roles and the
+	// user ID otherwise.
+	PrincipalID  string
+	IdentityType string
+}
+
+// Credential is an access key accepted by the server.
+type Credential struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	// SecurityToken must be sent with the requests of STS credentials.
+	SecurityToken string
+	// Expiration is when STS credentials expire, zero for access keys.
+	Expiration time.Time
+	Identity   Identity
+}
+
+// Role is a RAM role that can be assumed with AssumeRole.
+type Role struct {
+	ARN    string
+	RoleID string
+}
+
+// Server is a local STS endpoint. Credentials and roles can be added while it
+// is serving.
+type Server struct {
+	*httptest.Server
+
+	lock        sync.Mutex
+	now         func() time.Time
+	credentials map[string]Credential
+	roles       map[string]Role
+	// nonces are the signature nonces seen within MaxClockSkew, by the time
+	// they can be forgotten.
+	nonces   map[string]time.Time
+	failures []failure
+	requests []string
+}
+
+// failure is an error injected with Fail.
+type failure struct {
+	statusCode int
+	code       string
+}
+
+// NewServer starts an HTTPS server. Point clients at Endpoint and trust
+// RootCAs.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+// NewHTTPServer starts a plain HTTP server, for the Alibaba Cloud SDK clients
+// which cannot be given the root CAs of NewServer.
+func NewHTTPServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		now:         time.Now,
+		credentials: map[string]Credential{},
+		roles:       map[string]Role{},
+		nonces:      map[string]time.Time{},
+	}
+}
+
+// Endpoint returns the host:port of the server.
+func (s *Server) Endpoint() string {
+	return strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+}
+
+// RootCAs returns a pool trusting the certificate of a server started with
+// NewServer.
+func (s *Server) RootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	if cert := s.Certificate(); cert != nil {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// SetClock replaces the clock requests are validated with.
+func (s *Server) SetClock(now func() time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.now = now
+}
+
+// AddCredential accepts the requests signed with a credential.
+func (s *Server) AddCredential(credential Credential) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credentials[credential.AccessKeyID] = credential
+}
+
+// RemoveCredential revokes a credential.
+func (s *Server) RemoveCredential(accessKeyID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.credentials, accessKeyID)
+}
+
+// AddUser adds the access key of a RAM user and returns its credential.
+func (s *Server) AddUser(accountID, userName, userID string) Credential {
+	credential := Credential{
+		AccessKeyID:     "LTAI" + randomString(16),
+		AccessKeySecret: randomString(30),
+		Identity: Identity{
+			AccountID:    accountID,
+			ARN:          fmt.Sprintf("acs:ram::%s:user/%s", accountID, userName),
+			PrincipalID:  userID,
+			IdentityType: IdentityTypeRAMUser,
+		},
+	}
+	s.AddCredential(credential)
+	return credential
+}
+
+// AddRole makes a role assumable by any credential of the server.
+func (s *Server) AddRole(role Role) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.roles[strings.ToLower(role.ARN)] = role
+}
+
+// Fail makes the next n requests fail with an error code before they are
+// validated, e.g. 503 "ServiceUnavailable" or 400 "Throttling.User".
+func (s *Server) Fail(n int, statusCode int, code string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{statusCode: statusCode, code: code})
+	}
+}
+
+// Requests returns the actions of the requests served so far, in order, or
+// "" for requests that failed before their action was parsed.
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// ServeHTTP serves an STS request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := strings.ToUpper(uuid.NewV4().String())
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.failures) > 0 {
+		failure := s.failures[0]
+		s.failures = s.failures[1:]
+		s.requests = append(s.requests, "")
+		writeError(w, requestID, &apiError{failure.statusCode, failure.code, "injected failure"})
+		return
+	}
+
+	req, err := s.parse(r)
+	if err != nil {
+		s.requests = append(s.requests, "")
+		writeError(w, requestID, err)
+		return
+	}
+	s.requests = append(s.requests, req.action)
+	credential, err := s.authenticate(req)
+	if err != nil {
+		writeError(w, requestID, err)
+		return
+	}
+
+	switch req.action {
+	case "GetCallerIdentity":
+		writeJSON(w, http.StatusOK, s.getCallerIdentity(requestID, credential))
+	case "AssumeRole":
+		response, err := s.assumeRole(requestID, req)
+		if err != nil {
+			writeError(w, requestID, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+	default:
+		writeError(w, requestID, &apiError{http.StatusNotFound, "InvalidAction.NotFound", fmt.Sprintf("Specified api %s is not found, please check your url and method.", req.action)})
+	}
+}
+
+// authenticate validates a request and returns the credential that signed
+// it.
+func (s *Server) authenticate(req *request) (Credential, error) {
+	now := s.now()
+	if req.timestamp.Before(now.Add(-MaxClockSkew)) {
+		return Credential{}, &apiError{http.StatusBadRequest, "InvalidTimeStamp.Expired", "Specified time stamp or date value is expired."}
+	}
+	if req.timestamp.After(now.Add(MaxClockSkew)) {
+		return Credential{}, &apiError{http.StatusBadRequest, "InvalidTimeStamp.Expired", "Specified time stamp or date value is later than the server time."}
+	}
+
+	credential, ok := s.credentials[req.accessKeyID]
+	if !ok {
+		return Credential{}, &apiError{http.StatusNotFound, "InvalidAccessKeyId.NotFound", "Specified access key is not found."}
+	}
+	if credential.SecurityToken != "" {
+		if req.securityToken == "" {
+			return Credential{}, &apiError{http.StatusBadRequest, "MissingSecurityToken", "SecurityToken is mandatory for this action."}
+		}
+		if req.securityToken != credential.SecurityToken {
+			return Credential{}, &apiError{http.StatusBadRequest, "InvalidSecurityToken.MismatchWithAccessKey", "Specified SecurityToken mismatch with the AccessKey."}
+		}
+		if !credential.Expiration.IsZero() && now.After(credential.Expiration) {
+			return Credential{}, &apiError{http.StatusBadRequest, "InvalidSecurityToken.Expired", "Specified SecurityToken is expired."}
+		}
+	}
+	if !req.signedWith(credential.AccessKeySecret) {
+		return Credential{}, &apiError{http.StatusBadRequest, "SignatureDoesNotMatch", "Specified signature is not matched with our calculation."}
+	}
+
+	for nonce, expiry := range s.nonces {
+		if now.After(expiry) {
+			delete(s.nonces, nonce)
+		}
+	}
+	if _, used := s.nonces[req.nonce]; used {
+		return Credential{}, &apiError{http.StatusBadRequest, "SignatureNonceUsed", "Specified signature nonce was used already."}
+	}
+	s.nonces[req.nonce] = req.timestamp.Add(MaxClockSkew)
+	return credential, nil
+}
+
+type getCallerIdentityResponse struct {
+	RequestID    string `json:"RequestId"`
+	AccountID    string `json:"AccountId"`
+	UserID       string `json:"UserId,omitempty"`
+	RoleID       string `json:"RoleId,omitempty"`
+	Arn          string `json:"Arn"`
+	IdentityType string `json:"IdentityType"`
+	PrincipalID  string `json:"PrincipalId"`
+}
+
+func (s *Server) getCallerIdentity(requestID string, credential Credential) *getCallerIdentityResponse {
+	identity := credential.Identity
+	response := &getCallerIdentityResponse{
+		RequestID:    requestID,
+		AccountID:    identity.AccountID,
+		Arn:          identity.ARN,
+		IdentityType: identity.IdentityType,
+		PrincipalID:  identity.PrincipalID,
+	}
+	if identity.IdentityType == IdentityTypeAssumedRoleUser {
+		response.RoleID = strings.SplitN(identity.PrincipalID, ":", 2)[0]
+	} else {
+		response.UserID = identity.PrincipalID
+	}
+	return response
+}
+
+type assumeRoleResponse struct {
+	RequestID       string `json:"RequestId"`
+	AssumedRoleUser struct {
+		Arn           string `json:"Arn"`
+		AssumedRoleID string `json:"AssumedRoleId"`
+	} `json:"AssumedRoleUser"`
+	Credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		AccessKeySecret string `json:"AccessKeySecret"`
+		SecurityToken   string `json:"SecurityToken"`
+		Expiration      string `json:"Expiration"`
+	} `json:"Credentials"`
+}
+
+func (s *Server) assumeRole(requestID string, req *request) (*assumeRoleResponse, error) {
+	roleARN, sessionName := req.params["RoleArn"], req.params["RoleSessionName"]
+	if roleARN == "" {
+		return nil, &apiError{http.StatusBadRequest, "MissingRoleArn", "RoleArn is mandatory for this action."}
+	}
+	if sessionName == "" {
+		return nil, &apiError{http.StatusBadRequest, "MissingRoleSessionName", "RoleSessionName is mandatory for this action."}
+	}
+	duration := defaultAssumeRoleDuration
+	if raw := req.params["DurationSeconds"]; raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		duration = time.Duration(seconds) * time.Second
+		if err != nil || duration < minAssumeRoleDuration || duration > maxAssumeRoleDuration {
+			return nil, &apiError{http.StatusBadRequest, "InvalidParameter.DurationSeconds", "The parameter DurationSeconds is wrongly formed."}
+		}
+	}
+	role, ok := s.roles[strings.ToLower(roleARN)]
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, "EntityNotExist.Role", "The role not exists."}
+	}
+	parts := strings.SplitN(role.ARN, ":", 5)
+	if len(parts) != 5 || !strings.HasPrefix(parts[4], "role/") {
+		return nil, &apiError{http.StatusBadRequest, "InvalidParameter.RoleArn", "The parameter RoleArn is wrongly formed."}
+	}
+
+	credential := Credential{
+		AccessKeyID:     "STS." + randomString(24),
+		AccessKeySecret: randomString(44),
+		SecurityToken:   randomString(64),
+		Expiration:      s.now().Add(duration).UTC(),
+		Identity: Identity{
+			AccountID:    parts[3],
+			ARN:          fmt.Sprintf("acs:ram::%s:assumed-role/%s/%s", parts[3], strings.TrimPrefix(parts[4], "role/"), sessionName),
+			PrincipalID:  role.RoleID + ":" + sessionName,
+			IdentityType: IdentityTypeAssumedRoleUser,
+		},
+	}
+	s.credentials[credential.AccessKeyID] = credential
+
+	response := &assumeRoleResponse{RequestID: requestID}
+	response.AssumedRoleUser.Arn = credential.Identity.ARN
+	response.AssumedRoleUser.AssumedRoleID = credential.Identity.PrincipalID
+	response.Credentials.AccessKeyID = credential.AccessKeyID
+	response.Credentials.AccessKeySecret = credential.AccessKeySecret
+	response.Credentials.SecurityToken = credential.SecurityToken
+	response.Credentials.Expiration = credential.Expiration.Format(timeFormat)
+	return response, nil
+}
+
+// apiError is an STS error response.
+type apiError struct {
+	statusCode int
+	code       string
+	message    string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+type errorResponse struct {
+	RequestID string `json:"RequestId"`
+	HostID    string `json:"HostId"`
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+	Recommend string `json:"Recommend"`
+}
+
+func writeError(w http.ResponseWriter, requestID string, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{http.StatusBadRequest, "InvalidParameter", err.Error()}
+	}
+	writeJSON(w, e.statusCode, &errorResponse{
+		RequestID: requestID,
+		HostID:    "sts.aliyuncs.com",
+		Code:      e.code,
+		Message:   e.message,
+		Recommend: "https://api.aliyun.com/troubleshoot?q=" + e.code,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomString(n int) string {
+	var s string
+	for len(s) < n {
+		s += strings.Replace(uuid.NewV4().String(), "-", "", -1)
+	}
+	return s[:n]
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ststest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/client"
+	sts "github.com/alibabacloud-go/sts-20150401/client"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// call sends the STS request of a token to the server like the verifier does.
+func call(t *testing.T, s *Server, token string) (int, map[string]string) {
+	var req *http.Request
+	switch {
+	case strings.HasPrefix(token, v1Prefix):
+		rawURL, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, v1Prefix))
+		if err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+		req, _ = http.NewRequest(http.MethodGet, strings.Replace(string(rawURL), "https://sts.aliyuncs.com", s.URL, 1), nil)
+	case strings.HasPrefix(token, v2Prefix):
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, v2Prefix))
+		if err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+		var v2 v2Token
+		if err := json.Unmarshal(raw, &v2); err != nil {
+			t.Fatalf("expected error to be nil was %q", err)
+		}
+		req, _ = http.NewRequest(v2.Method, s.URL+v2.Path, nil)
+		for name, value := range v2.Headers {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	defer resp.Body.Close()
+	body := map[string]string{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	user := s.AddUser("123456789012", "alice", "300800000000000000")
+
+	for name, token := range map[string]func(Credential, string, time.Time) string{"v1": V1Token, "v2": V2Token} {
+		status, body := call(t, s, token(user, "cluster", time.Now()))
+		if status != http.StatusOK {
+			t.Fatalf("%s: expected status 200 but got %d: %v", name, status, body)
+		}
+		if body["Arn"] != "acs:ram::123456789012:user/alice" || body["PrincipalId"] != "300800000000000000" || body["IdentityType"] != IdentityTypeRAMUser {
+			t.Errorf("%s: unexpected identity %v", name, body)
+		}
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	user := s.AddUser("123456789012", "alice", "300800000000000000")
+	wrongSecret := user
+	wrongSecret.AccessKeySecret = "wrong"
+	unknown := user
+	unknown.AccessKeyID = "LTAIunknown"
+
+	replayed := V1Token(user, "cluster", time.Now())
+	call(t, s, replayed)
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"v1 signature", V1Token(wrongSecret, "cluster", time.Now()), "SignatureDoesNotMatch"},
+		{"v2 signature", V2Token(wrongSecret, "cluster", time.Now()), "SignatureDoesNotMatch"},
+		{"unknown key", V2Token(unknown, "cluster", time.Now()), "InvalidAccessKeyId.NotFound"},
+		{"expired", V1Token(user, "cluster", time.Now().Add(-MaxClockSkew-time.Minute)), "InvalidTimeStamp.Expired"},
+		{"future", V2Token(user, "cluster", time.Now().Add(MaxClockSkew+time.Minute)), "InvalidTimeStamp.Expired"},
+		{"nonce", replayed, "SignatureNonceUsed"},
+	}
+	for _, test := range tests {
+		status, body := call(t, s, test.token)
+		if status == http.StatusOK || body["Code"] != test.code {
+			t.Errorf("%s: expected code %s but got %d %v", test.name, test.code, status, body)
+		}
+	}
+
+	s.Fail(1, http.StatusServiceUnavailable, "ServiceUnavailable")
+	if status, body := call(t, s, V1Token(user, "cluster", time.Now())); status != http.StatusServiceUnavailable || body["Code"] != "ServiceUnavailable" {
+		t.Errorf("expected the injected failure but got %d %v", status, body)
+	}
+	if status, _ := call(t, s, V1Token(user, "cluster", time.Now())); status != http.StatusOK {
+		t.Errorf("expected only one request to fail but got %d", status)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	s := NewHTTPServer()
+	defer s.Close()
+	user := s.AddUser("123456789012", "alice", "300800000000000000")
+	s.AddRole(Role{ARN: "acs:ram::123456789012:role/Developers", RoleID: "300900000000000000"})
+
+	client, err := sts.NewClient(&openapi.Config{
+		AccessKeyId:     tea.String(user.AccessKeyID),
+		AccessKeySecret: tea.String(user.AccessKeySecret),
+		Endpoint:        tea.String(s.Endpoint()),
+		Protocol:        tea.String("http"),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	resp, err := client.AssumeRole(&sts.AssumeRoleRequest{
+		RoleArn:         tea.String("acs:ram::123456789012:role/developers"),
+		RoleSessionName: tea.String("alice"),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	if arn := tea.StringValue(resp.Body.AssumedRoleUser.Arn); arn != "acs:ram::123456789012:assumed-role/Developers/alice" {
+		t.Errorf("unexpected assumed role ARN %s", arn)
+	}
+
+	assumed := Credential{
+		AccessKeyID:     tea.StringValue(resp.Body.Credentials.AccessKeyId),
+		AccessKeySecret: tea.StringValue(resp.Body.Credentials.AccessKeySecret),
+		SecurityToken:   tea.StringValue(resp.Body.Credentials.SecurityToken),
+	}
+	status, body := call(t, s, V1Token(assumed, "cluster", time.Now()))
+	if status != http.StatusOK || body["PrincipalId"] != "300900000000000000:alice" || body["IdentityType"] != IdentityTypeAssumedRoleUser {
+		t.Errorf("unexpected identity %d %v", status, body)
+	}
+	assumed.SecurityToken = ""
+	if status, body := call(t, s, V1Token(assumed, "cluster", time.Now())); body["Code"] != "MissingSecurityToken" {
+		t.Errorf("expected a missing security token but got %d %v", status, body)
+	}
+
+	_, err = client.AssumeRole(&sts.AssumeRoleRequest{
+		RoleArn:         tea.String("acs:ram::123456789012:role/unknown"),
+		RoleSessionName: tea.String("alice"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "EntityNotExist.Role") {
+		t.Errorf("expected EntityNotExist.Role but got %v", err)
+	}
+}
@@ -19,12 +19,13 @@ package token
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/arn"
 	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/utils"
-	"github.com/AliyunContainerService/ack-ram-tool/pkg/credentials/provider"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/client"
 	sts "github.com/alibabacloud-go/sts-20150401/client"
 	"github.com/alibabacloud-go/tea/tea"
@@ -34,6 +35,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	clientauthv1alpha1 "k8s.io/client-go/pkg/apis/clientauthentication/v1alpha1"
 	"net/http"
 	"net/url"
@@ -75,6 +77,9 @@ type Identity struct {
 	// in conjunction with CloudTrail to determine the identity of the individual
 	// if the individual assumed an RAM role before making the request.
 	AccessKeyID string
+
+	// ClusterID is the cluster ID the token was generated for.
+	ClusterID string
 }
 
 const (
@@ -98,6 +103,9 @@ const (
 	defaultRoleSessionName = "ack-ram-authenticator"
 )
 
+// stsCallWaitTimeout is how long a verification waits for a free STS call slot.
+var stsCallWaitTimeout = 5 * time.Second
+
 // Token is generated and used by Kubernetes client-go to authenticate with a Kubernetes cluster.
 type Token struct {
 	Token      string
@@ -122,11 +130,43 @@ func (e FormatError) Error() string {
 	return "input token was not properly formatted: " + e.message
 }
 
+// NewFormatError creates a FormatError with the given message.
+func NewFormatError(m string) FormatError {
+	return FormatError{message: m}
+}
+
+// AudienceError is returned when none of the audiences requested by a
+// TokenReview is accepted for the cluster the token was generated for.
+type AudienceError struct {
+	message string
+}
+
+func (e AudienceError) Error() string {
+	return "token audience is not accepted: " + e.message
+}
+
+// ThrottledError is returned when a request exceeded one of the configured
+// rate limits and was rejected without calling STS.
+type ThrottledError struct {
+	message string
+}
+
+func (e ThrottledError) Error() string {
+	return "request throttled: " + e.message
+}
+
+// NewThrottledError creates a error of type Throttled.
+func NewThrottledError(m string) ThrottledError {
+	return ThrottledError{message: m}
+}
+
 // STSError is returned when there was either an error calling STS or a problem
 // processing the data returned from STS.
 type STSError struct {
 	message     string
 	raiseToUser bool
+	statusCode  int
+	code        string
 }
 
 func (e STSError) RaiseToUser() bool {
@@ -141,6 +181,16 @@ func (e STSError) Error() string {
 	return "sts getCallerIdentity failed: " + e.message
 }
 
+// Definitive returns true if STS itself rejected the request with a client
+// error, meaning that retrying the same token will not succeed either.
+// Transport failures, server errors and throttling are not definitive.
+func (e STSError) Definitive() bool {
+	if e.code == "" || e.statusCode < 400 || e.statusCode >= 500 {
+		return false
+	}
+	return !strings.HasPrefix(e.code, "Throttling")
+}
+
 // NewSTSError creates a error of type STS.
 func NewSTSError(m string) STSError {
 	return STSError{message: m}
@@ -342,12 +392,69 @@ func (g generator) FormatJSON(token Token) string {
 // Verifier validates tokens by calling STS and returning the associated identity.
 type Verifier interface {
 	Verify(token string) (*Identity, error)
+	// VerifyAudiences returns the audiences out of the requested ones that are
+	// accepted for the cluster of a verified identity. It returns nil if no
+	// audience was requested or the cluster has no audiences configured.
+	VerifyAudiences(identity *Identity, audiences []string) ([]string, error)
+}
+
+// VerifierOptions is passed to NewVerifierWithOptions to provide an extensible verifier interface
+type VerifierOptions struct {
+	Region    string
+	ClusterID string
+	// ClusterIDs are accepted in addition to ClusterID. The Identity of a
+	// token reports the cluster it was generated for.
+	ClusterIDs []string
+	// Audiences maps a cluster ID to the TokenReview audiences accepted for
+	// tokens generated for that cluster.
+	Audiences map[string][]string
+	// CacheSize is the maximum number of verified tokens kept in memory.
+	// Caching is disabled when it is 0.
+	CacheSize int
+	// CacheNegativeTTL is how long definitive STS denials are cached.
+	CacheNegativeTTL time.Duration
+	// MaxConcurrentSTSCalls caps the number of STS calls in flight.
+	// Unlimited when it is 0.
+	MaxConcurrentSTSCalls int
+	// STSEndpoint is the host[:port] tokens are verified against instead of
+	// the STS endpoint of Region.
+	STSEndpoint string
+	// STSEndpoints are the host[:port] tried in order to verify tokens. They
+	// take precedence over STSEndpoint and default to DefaultSTSEndpoints.
+	STSEndpoints []string
+	// STSMaxRetries is how many times an STS call failing to connect is
+	// retried on the next endpoint. Calls of v2 tokens are never retried.
+	STSMaxRetries int
+	// STSRetryBackoff is the base of the jittered exponential backoff
+	// between the attempts of an STS call.
+	STSRetryBackoff time.Duration
+	// STSBreakerFailures consecutive failures of an endpoint open its
+	// circuit breaker for STSBreakerCooldown, trying it after the other
+	// endpoints meanwhile. Disabled when STSBreakerFailures is 0.
+	STSBreakerFailures int
+	STSBreakerCooldown time.Duration
+	// RootCAs verify the certificate of the STS endpoint instead of the
+	// system roots.
+	RootCAs *x509.CertPool
+	// ClockSkew is how far the Timestamp of a v1 token may be off the local
+	// clock before it is rejected without calling STS.
+	ClockSkew time.Duration
+	// NonceStore records the signature nonces of tokens verified with
+	// VerifyAndRecord. Replay protection is disabled when it is nil.
+	NonceStore NonceStore
+	// NoncePolicy is how often the same token is accepted.
+	NoncePolicy NoncePolicy
 }
 
 type tokenVerifier struct {
 	client      *http.Client
 	clusterID   string
+	clusterIDs  sets.String
 	stsEndpoint string
+	endpoints   *endpointPool
+	audiences   map[string]sets.String
+	stsCalls    chan struct{}
+	clockSkew   time.Duration
 }
 
 func (v tokenVerifier) getClusterID() string {
@@ -356,11 +463,22 @@ func (v tokenVerifier) getClusterID() string {
 
 // NewVerifier creates a Verifier that is bound to the clusterID and uses the default http client.
 func NewVerifier(region, clusterID string) Verifier {
-	endpoint := provider.GetSTSEndpoint(region, true)
-	if region == "" {
-		endpoint = provider.GetSTSEndpoint(region, false)
+	return NewVerifierWithOptions(VerifierOptions{
+		Region:    region,
+		ClusterID: clusterID,
+	})
+}
+
+// NewVerifierWithOptions creates a Verifier from the given options.
+func NewVerifierWithOptions(options VerifierOptions) Verifier {
+	endpoints := options.STSEndpoints
+	if len(endpoints) == 0 && options.STSEndpoint != "" {
+		endpoints = []string{options.STSEndpoint}
+	}
+	if len(endpoints) == 0 {
+		endpoints = DefaultSTSEndpoints(options.Region)
 	}
-	log.Warnf("will use %s as sts endpoint", endpoint)
+	log.Warnf("will use %s as sts endpoints", strings.Join(endpoints, ", "))
 
 	rt := http.DefaultTransport.(*http.Transport).Clone()
 	if v, err := strconv.Atoi(os.Getenv("STS_MAX_IDLE_CONNS_PER_HOST")); err == nil && v > 1 {
@@ -369,6 +487,9 @@ func NewVerifier(region, clusterID string) Verifier {
 		rt.MaxIdleConnsPerHost = 5
 	}
 	log.Warnf("will use %d as value of MaxIdleConnsPerHost", rt.MaxIdleConnsPerHost)
+	if options.RootCAs != nil {
+		rt.TLSClientConfig = &tls.Config{RootCAs: options.RootCAs}
+	}
 
 	client := &http.Client{
 		Transport: rt,
@@ -377,11 +498,35 @@ func NewVerifier(region, clusterID string) Verifier {
 		},
 	}
 
-	return tokenVerifier{
+	audiences := make(map[string]sets.String, len(options.Audiences))
+	for clusterID, accepted := range options.Audiences {
+		if len(accepted) > 0 {
+			audiences[clusterID] = sets.NewString(accepted...)
+		}
+	}
+
+	v := tokenVerifier{
 		client:      client,
-		clusterID:   clusterID,
-		stsEndpoint: endpoint,
+		clusterID:   options.ClusterID,
+		clusterIDs:  sets.NewString(options.ClusterIDs...),
+		stsEndpoint: endpoints[0],
+		endpoints:   newEndpointPool(endpoints, options.STSMaxRetries, options.STSRetryBackoff, options.STSBreakerFailures, options.STSBreakerCooldown),
+		audiences:   audiences,
+		clockSkew:   options.ClockSkew,
+	}
+	if options.MaxConcurrentSTSCalls > 0 {
+		v.stsCalls = make(chan struct{}, options.MaxConcurrentSTSCalls)
 	}
+
+	var verifier Verifier = newCoalescingVerifier(v)
+	if options.CacheSize > 0 {
+		log.Infof("will cache up to %d verified tokens", options.CacheSize)
+		verifier = newCachingVerifier(verifier, options.CacheSize, options.CacheNegativeTTL)
+	}
+	if options.NonceStore != nil {
+		verifier = newNonceVerifier(verifier, options.NonceStore, options.NoncePolicy)
+	}
+	return verifier
 }
 
 // verify a sts host
@@ -395,13 +540,47 @@ func (v tokenVerifier) verifyHost(host string) error {
 
 // verify a sts host
 func (v tokenVerifier) verifyClusterID(clusterID string) error {
-	if v.clusterID != clusterID {
+	if v.clusterID != clusterID && !v.clusterIDs.Has(clusterID) {
 		return FormatError{fmt.Sprintf("unexpected clusterid %s in token", clusterID)}
 	}
 
 	return nil
 }
 
+// v1RequiredParameters are the lower case query parameters every v1 token is
+// signed with.
+var v1RequiredParameters = []string{"accesskeyid", "signature", "signaturemethod", "signatureversion", "signaturenonce", "timestamp", "version"}
+
+// verifyV1Parameters checks the signature parameters of a v1 pre-signed URL,
+// and that STS would not reject its Timestamp, allowing for clock skew.
+func (v tokenVerifier) verifyV1Parameters(queryParamsLower url.Values) error {
+	for _, key := range v1RequiredParameters {
+		if queryParamsLower.Get(key) == "" {
+			return FormatError{fmt.Sprintf("missing %s parameter in pre-signed URL", key)}
+		}
+	}
+	if method := queryParamsLower.Get("signaturemethod"); method != "HMAC-SHA1" {
+		return FormatError{fmt.Sprintf("unexpected SignatureMethod %q in pre-signed URL, expected HMAC-SHA1", method)}
+	}
+	if version := queryParamsLower.Get("signatureversion"); version != stsSignVersion {
+		return FormatError{fmt.Sprintf("unexpected SignatureVersion %q in pre-signed URL, expected %s", version, stsSignVersion)}
+	}
+
+	rawTimestamp := queryParamsLower.Get("timestamp")
+	timestamp, err := time.Parse(timeFormat, rawTimestamp)
+	if err != nil {
+		return FormatError{fmt.Sprintf("invalid Timestamp %q in pre-signed URL, expected the format %s", rawTimestamp, timeFormat)}
+	}
+	now := time.Now()
+	if expiration := timestamp.Add(presignedURLExpiration); now.After(expiration.Add(v.clockSkew)) {
+		return FormatError{fmt.Sprintf("pre-signed URL expired at %s, generate a new token", expiration.UTC().Format(timeFormat))}
+	}
+	if timestamp.After(now.Add(v.clockSkew)) {
+		return FormatError{fmt.Sprintf("pre-signed URL Timestamp %s is in the future, check the clock of the client", rawTimestamp)}
+	}
+	return nil
+}
+
 // Verify a token is valid for the specified clusterID. On success, returns an
 // Identity that contains information about the RAM principal that created the
 // token. On failure, returns nil and a non-nil error.
@@ -423,11 +602,11 @@ func (v tokenVerifier) Verify(token string) (*Identity, error) {
 	}
 
 	var req *http.Request
-	var accessKeyId string
+	var accessKeyId, clusterID string
 	switch {
 	case strings.HasPrefix(token, v2Prefix):
 		log.Infof("start to parse token with prefix %s", v2Prefix)
-		accessKeyId, req, err = v.parseV2Token(string(tokenBytes))
+		accessKeyId, clusterID, req, err = v.parseV2Token(string(tokenBytes))
 		if err != nil {
 			return nil, FormatError{err.Error()}
 		}
@@ -466,8 +645,12 @@ func (v tokenVerifier) Verify(token string) (*Identity, error) {
 		if queryParamsLower.Get("action") != "GetCallerIdentity" {
 			return nil, FormatError{"unexpected action parameter in pre-signed URL"}
 		}
+		if err = v.verifyV1Parameters(queryParamsLower); err != nil {
+			return nil, err
+		}
 
-		if err = v.verifyClusterID(queryParamsLower.Get("clusterid")); err != nil {
+		clusterID = queryParamsLower.Get("clusterid")
+		if err = v.verifyClusterID(clusterID); err != nil {
 			return nil, err
 		}
 		accessKeyId = queryParamsLower.Get("accesskeyid")
@@ -476,8 +659,21 @@ func (v tokenVerifier) Verify(token string) (*Identity, error) {
 		req.Header.Set("User-Agent", userAgentV1)
 	}
 
+	if v.stsCalls != nil {
+		timer := time.NewTimer(stsCallWaitTimeout)
+		select {
+		case v.stsCalls <- struct{}{}:
+			timer.Stop()
+			defer func() { <-v.stsCalls }()
+		case <-timer.C:
+			return nil, ThrottledError{"too many concurrent sts calls"}
+		}
+	}
+
 	req.Header.Set("accept", "application/json")
-	response, err := v.client.Do(req)
+	// the signature of a v2 token covers the host, so it is only valid on
+	// the endpoint it is sent to first
+	response, err := v.endpoints.do(v.client, req, !strings.HasPrefix(token, v2Prefix))
 	if err != nil {
 		// special case to avoid printing the full URL if possible
 		if urlErr, ok := err.(*url.Error); ok {
@@ -523,6 +719,7 @@ func (v tokenVerifier) Verify(token string) (*Identity, error) {
 		return nil, newOpenAPIErr(http.StatusBadRequest, nil, err)
 	}
 	id.AccessKeyID = accessKeyId
+	id.ClusterID = clusterID
 
 	// The user ID is either UserID:SessionName (for assumed roles) or just
 	// UserID (for RAM User principals).
@@ -541,6 +738,30 @@ func (v tokenVerifier) Verify(token string) (*Identity, error) {
 	return id, nil
 }
 
+// VerifyAudiences returns the requested audiences accepted for the cluster the
+// identity's token was generated for. Clusters without configured audiences
+// are audience agnostic and accept any request.
+func (v tokenVerifier) VerifyAudiences(identity *Identity, audiences []string) ([]string, error) {
+	if len(audiences) == 0 {
+		return nil, nil
+	}
+	accepted, ok := v.audiences[identity.ClusterID]
+	if !ok {
+		return nil, nil
+	}
+
+	var matched []string
+	for _, audience := range audiences {
+		if accepted.Has(audience) {
+			matched = append(matched, audience)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, AudienceError{fmt.Sprintf("cluster %s does not accept any of the audiences %v", identity.ClusterID, audiences)}
+	}
+	return matched, nil
+}
+
 // NewJSONStruct new a json struct
 func NewJSONStruct() *JSONStruct {
 	return &JSONStruct{}
@@ -9,9 +9,15 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/AliyunContainerService/ack-ram-authenticator/pkg/token/ststest"
+	openapi "github.com/alibabacloud-go/darabonba-openapi/client"
+	sts "github.com/alibabacloud-go/sts-20150401/client"
+	"github.com/alibabacloud-go/tea/tea"
 )
 
 func validationErrorTest(t *testing.T, token string, expectedErr string) {
@@ -38,9 +44,36 @@ var (
 	now        = time.Now()
 	timeStr    = now.UTC().Format("2006-01-02T15:04:05Z")
 	validToken = toToken(validURL)
-	validURL   = fmt.Sprintf("https://sts.aliyuncs.com/?action=GetCallerIdentity&SignatureVersion=1.0&Format=JSON&Timestamp=%s", timeStr)
+	validURL   = v1URL(nil)
 )
 
+// v1URL returns a pre-signed URL with the parameters of a valid token,
+// replaced by params. Parameters replaced by "" are left out.
+func v1URL(params map[string]string) string {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"Action":           "GetCallerIdentity",
+		"Version":          stsAPIVersion,
+		"Format":           "JSON",
+		"AccessKeyId":      "LTAIexample",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureVersion": "1.0",
+		"SignatureNonce":   "nonce",
+		"Timestamp":        timeStr,
+		"Signature":        "signature",
+	} {
+		query.Set(key, value)
+	}
+	for key, value := range params {
+		if value == "" {
+			query.Del(key)
+		} else {
+			query.Set(key, value)
+		}
+	}
+	return "https://sts.aliyuncs.com/?" + query.Encode()
+}
+
 func toToken(url string) string {
 	return v1Prefix + base64.StdEncoding.EncodeToString([]byte(url))
 }
@@ -97,8 +130,9 @@ func TestVerifyTokenPreSTSValidations(t *testing.T) {
 	b := make([]byte, maxTokenLenBytes+1, maxTokenLenBytes+1)
 	s := string(b)
 	validationErrorTest(t, s, "token is too large")
-	validationErrorTest(t, "k8s-ack-v2.asdfasdfa", "token is missing expected \"k8s-ack-v1.\" prefix")
+	validationErrorTest(t, "k8s-ack-v3.asdfasdfa", "token is missing expected prefix")
 	validationErrorTest(t, "k8s-ack-v1.decodingerror", "illegal base64 data")
+	validationErrorTest(t, "k8s-ack-v2.decodingerror", "illegal base64 data")
 	validationErrorTest(t, toToken(":ab:cd.af:/asda"), "missing protocol scheme")
 	validationErrorTest(t, toToken("http://"), "unexpected scheme")
 	validationErrorTest(t, toToken("https://google.com"), fmt.Sprintf("unexpected hostname %q in pre-signed URL", "google.com"))
@@ -109,15 +143,44 @@ func TestVerifyTokenPreSTSValidations(t *testing.T) {
 	validationErrorTest(t, toToken("https://sts.aliyuncs.com/?action=NotGetCallerIdenity"), "unexpected action parameter in pre-signed URL")
 }
 
+func TestVerifyV1Parameters(t *testing.T) {
+	for _, key := range []string{"AccessKeyId", "Signature", "SignatureMethod", "SignatureVersion", "SignatureNonce", "Timestamp", "Version"} {
+		validationErrorTest(t, toToken(v1URL(map[string]string{key: ""})), fmt.Sprintf("missing %s parameter", strings.ToLower(key)))
+	}
+	validationErrorTest(t, toToken(v1URL(map[string]string{"SignatureMethod": "HMAC-SHA256"})), `unexpected SignatureMethod "HMAC-SHA256"`)
+	validationErrorTest(t, toToken(v1URL(map[string]string{"SignatureVersion": "2.0"})), `unexpected SignatureVersion "2.0"`)
+	validationErrorTest(t, toToken(v1URL(map[string]string{"Timestamp": "yesterday"})), `invalid Timestamp "yesterday"`)
+
+	at := func(d time.Duration) string {
+		return toToken(v1URL(map[string]string{"Timestamp": now.Add(d).UTC().Format(timeFormat)}))
+	}
+	validationErrorTest(t, at(-presignedURLExpiration-time.Minute), "pre-signed URL expired at")
+	validationErrorTest(t, at(time.Minute), "is in the future")
+
+	skewed := func() tokenVerifier {
+		v := newVerifier(200, jsonResponse("acs:ram::123456789012:user/Alice", "123456789012", "Alice"), nil).(tokenVerifier)
+		v.clockSkew = 2 * time.Minute
+		return v
+	}
+	for _, token := range []string{at(-presignedURLExpiration - time.Minute), at(time.Minute)} {
+		if _, err := skewed().Verify(token); err != nil {
+			t.Errorf("expected a timestamp within the clock skew to be accepted, got %q", err)
+		}
+	}
+	if _, err := skewed().Verify(at(3 * time.Minute)); err == nil {
+		t.Errorf("expected a timestamp beyond the clock skew to be rejected")
+	}
+}
+
 func TestVerifyHTTPError(t *testing.T) {
 	_, err := newVerifier(0, "", errors.New("an error")).Verify(validToken)
-	errorContains(t, err, "error during GET: an error")
+	errorContains(t, err, "sts getCallerIdentity failed: call sts.GetCallerIdentity failed: Bad Request, an error")
 	assertSTSError(t, err)
 }
 
 func TestVerifyHTTP403(t *testing.T) {
 	_, err := newVerifier(403, " ", nil).Verify(validToken)
-	errorContains(t, err, "error from RAM (expected 200, got")
+	errorContains(t, err, "sts getCallerIdentity failed: call sts.GetCallerIdentity failed: Forbidden")
 	assertSTSError(t, err)
 }
 
@@ -209,3 +272,100 @@ func TestVerifyCanonicalARN(t *testing.T) {
 		t.Errorf("expected CannonicalARN to be %q but was %q", canonicalARN, identity.CanonicalARN)
 	}
 }
+
+func TestVerifyAudiences(t *testing.T) {
+	v := NewVerifierWithOptions(VerifierOptions{
+		ClusterID: "c1",
+		Audiences: map[string][]string{"c1": {"kube-apiserver", "metrics-server"}},
+	})
+	identity := &Identity{ClusterID: "c1"}
+
+	matched, err := v.VerifyAudiences(identity, nil)
+	if err != nil || matched != nil {
+		t.Errorf("expected no audiences and no error when none requested, got %v, %v", matched, err)
+	}
+
+	matched, err = v.VerifyAudiences(identity, []string{"other", "metrics-server"})
+	if err != nil {
+		t.Errorf("expected error to be nil was %q", err)
+	}
+	if len(matched) != 1 || matched[0] != "metrics-server" {
+		t.Errorf("expected matched audiences to be [metrics-server] but was %v", matched)
+	}
+
+	_, err = v.VerifyAudiences(identity, []string{"other"})
+	if _, ok := err.(AudienceError); !ok {
+		t.Errorf("expected err %v to be an AudienceError but was not", err)
+	}
+
+	matched, err = v.VerifyAudiences(&Identity{ClusterID: "c2"}, []string{"other"})
+	if err != nil || matched != nil {
+		t.Errorf("expected cluster without audiences to be audience agnostic, got %v, %v", matched, err)
+	}
+}
+
+func TestVerifyMaxConcurrentSTSCalls(t *testing.T) {
+	v := newVerifier(200, "", nil).(tokenVerifier)
+	v.stsCalls = make(chan struct{}, 1)
+	v.stsCalls <- struct{}{}
+	defer func(timeout time.Duration) { stsCallWaitTimeout = timeout }(stsCallWaitTimeout)
+	stsCallWaitTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	_, err := v.Verify(validToken)
+	if _, ok := err.(ThrottledError); !ok {
+		t.Errorf("expected err %v to be a ThrottledError but was not", err)
+	}
+	if time.Since(start) < stsCallWaitTimeout {
+		t.Errorf("expected verification to wait %v for a free slot", stsCallWaitTimeout)
+	}
+}
+
+func TestVerifyWithSTS(t *testing.T) {
+	server := ststest.NewServer()
+	defer server.Close()
+	user := server.AddUser("123456789012", "Alice", "300800000000000000")
+	v := NewVerifierWithOptions(VerifierOptions{
+		ClusterID:   "cluster",
+		STSEndpoint: server.Endpoint(),
+		RootCAs:     server.RootCAs(),
+	})
+
+	stsClient, err := sts.NewClient(&openapi.Config{
+		AccessKeyId:     tea.String(user.AccessKeyID),
+		AccessKeySecret: tea.String(user.AccessKeySecret),
+		Endpoint:        tea.String(server.Endpoint()),
+	})
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+	generated, err := generator{}.GetWithSTS("cluster", stsClient)
+	if err != nil {
+		t.Fatalf("expected error to be nil was %q", err)
+	}
+
+	for _, token := range []string{generated.Token, ststest.V2Token(user, "cluster", time.Now())} {
+		identity, err := v.Verify(token)
+		if err != nil {
+			t.Fatalf("expected %s token to verify but got %q", Version(token), err)
+		}
+		if identity.ARN != "acs:ram::123456789012:user/Alice" || identity.UserID != "300800000000000000" || identity.AccessKeyID != user.AccessKeyID || identity.ClusterID != "cluster" {
+			t.Errorf("unexpected identity %+v of %s token", identity, Version(token))
+		}
+	}
+
+	// the verifier is not cached, so a replayed token reaches STS again
+	_, err = v.Verify(generated.Token)
+	if stsErr, ok := err.(STSError); !ok || stsErr.code != "SignatureNonceUsed" || !stsErr.Definitive() {
+		t.Errorf("expected a definitive SignatureNonceUsed STSError but got %v", err)
+	}
+	server.RemoveCredential(user.AccessKeyID)
+	_, err = v.Verify(ststest.V2Token(user, "cluster", time.Now()))
+	if stsErr, ok := err.(STSError); !ok || stsErr.code != "InvalidAccessKeyId.NotFound" {
+		t.Errorf("expected an InvalidAccessKeyId.NotFound STSError but got %v", err)
+	}
+	_, err = v.Verify(ststest.V2Token(user, "other", time.Now()))
+	if _, ok := err.(FormatError); !ok {
+		t.Errorf("expected a FormatError for another cluster but got %v", err)
+	}
+}
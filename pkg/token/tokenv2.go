@@ -37,17 +37,17 @@ func init() {
 	userAgentV2 = fmt.Sprintf("%s/%s", userAgentV2, versionSuffix)
 }
 
-func (v tokenVerifier) parseV2Token(rawToken string) (string, *http.Request, error) {
+func (v tokenVerifier) parseV2Token(rawToken string) (string, string, *http.Request, error) {
 	var t V2Token
 	rawToken = strings.TrimPrefix(rawToken, v2Prefix)
 
 	if err := json.Unmarshal([]byte(rawToken), &t); err != nil {
 		log.Warnf("parse token failed: %+v", err)
-		return "", nil, err
+		return "", "", nil, err
 	}
 	if err := v.verifyClusterID(t.ClusterId); err != nil {
 		log.Warnf("[%s] found unexpected clusterId from token: %+v", v.clusterID, t.ClusterId)
-		return "", nil, err
+		return "", "", nil, err
 	}
 	if t.Headers == nil {
 		t.Headers = map[string]string{}
@@ -59,7 +59,7 @@ func (v tokenVerifier) parseV2Token(rawToken string) (string, *http.Request, err
 	reqURL := fmt.Sprintf("https://%s/", v.stsEndpoint)
 	req, err := http.NewRequest(http.MethodPost, reqURL, nil)
 	if err != nil {
-		return "", nil, err
+		return "", "", nil, err
 	}
 
 	query := req.URL.Query()
@@ -85,12 +85,12 @@ func (v tokenVerifier) parseV2Token(rawToken string) (string, *http.Request, err
 	req.Header.Set("User-Agent", userAgent)
 	if req.Header.Get("x-acs-action") != "GetCallerIdentity" {
 		log.Warnf("[%s] found unexpected x-acs-action from token: %+v", v.clusterID, req.Header.Get("x-acs-action"))
-		return "", nil, errors.New("unexpected action in token")
+		return "", "", nil, errors.New("unexpected action in token")
 	}
 
 	accessKeyId := getAccessKeyIdFromV2Header(req.Header.Get("Authorization"))
 
-	return accessKeyId, req, nil
+	return accessKeyId, t.ClusterId, req, nil
 }
 
 func getAccessKeyIdFromV2Header(rawV string) string {
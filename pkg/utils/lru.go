@@ -0,0 +1,88 @@
+/*
+Copyright 2021 by the contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "container/list"
+
+// LRU is a fixed size least-recently-used cache. It is not safe for
+// concurrent use, callers must provide their own locking.
+type LRU struct {
+	// OnEvicted optionally specifies a callback function to be
+	// executed when an entry is purged from the cache.
+	OnEvicted func(key string, value interface{})
+
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// NewLRU creates an LRU holding at most maxEntries entries.
+func NewLRU(maxEntries int) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Add adds or replaces a value, evicting the oldest entry when full.
+func (c *LRU) Add(key string, value interface{}) {
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*lruEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Get looks up a key's value and marks it as recently used.
+func (c *LRU) Get(key string) (interface{}, bool) {
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).value, true
+	}
+	return nil, false
+}
+
+// Remove removes the provided key from the cache.
+func (c *LRU) Remove(key string) {
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// Len returns the number of items in the cache.
+func (c *LRU) Len() int {
+	return c.ll.Len()
+}
+
+func (c *LRU) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	entry := e.Value.(*lruEntry)
+	delete(c.items, entry.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(entry.key, entry.value)
+	}
+}